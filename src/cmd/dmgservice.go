@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"io/ioutil"
@@ -21,10 +22,11 @@ var (
 	destroySession       bool
 	dmgProcessorType     string
 	sectionProcessorType string
+	httpAddress          string
 	helpFlag             bool
 )
 
-type serviceFunc func() error
+type serviceFunc func(ctx context.Context) error
 
 func main() {
 	var (
@@ -73,7 +75,14 @@ func main() {
 	if err != nil {
 		log.Fatalf("Error creating the DMG service: %v", err)
 	}
-	if err = service(); err != nil {
+	ctx, cancel := cmdutils.NewSignalContext()
+	defer cancel()
+	if ctx, err = cmdutils.ServeMetrics(ctx, httpAddress); err != nil {
+		log.Fatalf("Error starting the metrics server: %v", err)
+	}
+	err = service(ctx)
+	cmdutils.CloseProcessors(destroySession && err == nil)
+	if err != nil {
 		log.Fatalf("Error running the DMG service: %v", err)
 	}
 }
@@ -87,8 +96,9 @@ func registerArgs() (fs *flag.FlagSet) {
 	fs.StringVar(&jobName, "jobName", "dmg", "Job name")
 	fs.StringVar(&accountID, "A", "", "Grid account id")
 	fs.BoolVar(&destroySession, "destroySession", false, "If true it destroyes the session when it's done if no errors have been encountered")
-	fs.StringVar(&dmgProcessorType, "dmgProcessor", "drmaa1", "Job processor type {echo, local, drmaa1, drmaa2}")
-	fs.StringVar(&sectionProcessorType, "sectionProcessor", "local", "Job processor type {echo, local, drmaa1, drmaa2}")
+	fs.StringVar(&dmgProcessorType, "dmgProcessor", "drmaa1", "Job processor type "+process.ProcessorTypes())
+	fs.StringVar(&sectionProcessorType, "sectionProcessor", "local", "Job processor type "+process.ProcessorTypes())
+	fs.StringVar(&httpAddress, "httpAddress", "", "Address (host:port) of the HTTP server that exposes /metrics and /jobs; no server is started if not set")
 	fs.BoolVar(&helpFlag, "h", false, "Display command line usage flags")
 	return fs
 }
@@ -98,6 +108,16 @@ func createDMGService(operation string,
 	args *arg.Args,
 	resources config.Config) (serviceFunc, error) {
 	var err error
+	if dmgProcessorType == "script" {
+		// the section results are assembled right after the DMG jobs, which a script only runs later
+		if operation != "dmgImage" {
+			return nil, fmt.Errorf("The script DMG processor only supports the dmgImage operation, not %s", operation)
+		}
+		// the clients cannot read the address from the output of a server that did not start yet
+		if serverAddress, _ := args.GetStringArgValue("serverAddress"); serverAddress == "" {
+			return nil, fmt.Errorf("The script DMG processor requires the serverAddress")
+		}
+	}
 	dmgProcessor, err := cmdutils.CreateProcessor(dmgProcessorType,
 		accountID,
 		sessionName,
@@ -116,15 +136,15 @@ func createDMGService(operation string,
 
 	switch operation {
 	case "dmgImage":
-		return serviceFunc(func() error {
+		return serviceFunc(func(ctx context.Context) error {
 			j := process.Job{
 				Name:  jobName,
 				JArgs: args.Clone(),
 			}
-			return bandsProcessor.Run(j)
+			return bandsProcessor.Run(ctx, j)
 		}), nil
 	case "dmgSection":
-		return serviceFunc(func() error {
+		return serviceFunc(func(ctx context.Context) error {
 			sectionProcessor, err := cmdutils.CreateProcessor(sectionProcessorType,
 				accountID,
 				sessionName,
@@ -152,10 +172,10 @@ func createDMGService(operation string,
 					JobName:              fmt.Sprintf("%s-section", jobName),
 				},
 			}
-			return sectionProcessor.Run(j)
+			return sectionProcessor.Run(ctx, j)
 		}), nil
 	case "dmgSections":
-		return serviceFunc(func() error {
+		return serviceFunc(func(ctx context.Context) error {
 			sectionProcessor, err := cmdutils.CreateProcessor(sectionProcessorType,
 				accountID,
 				sessionName,
@@ -184,8 +204,11 @@ func createDMGService(operation string,
 				},
 			}
 			jobSplitter := dmg.ZSplitter{}
+			sectionProcessor = process.DecorateProcessor(sectionProcessor,
+				process.RetryProcessing(resources),
+				process.CacheProcessing(resources))
 			orthoviewsProcessor := process.NewParallelProcessor(sectionProcessor, jobSplitter, resources)
-			return orthoviewsProcessor.Run(j)
+			return orthoviewsProcessor.Run(ctx, j)
 		}), nil
 	default:
 		return nil, fmt.Errorf("Invalid DMG operation: %s. Supported values are:{dmgType, dmgSection, dmgSections}",
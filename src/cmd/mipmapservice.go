@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"io/ioutil"
@@ -20,10 +21,11 @@ var (
 	accountID            string
 	destroySession       bool
 	mipmapsProcessorType string
+	httpAddress          string
 	helpFlag             bool
 )
 
-type serviceFunc func() error
+type serviceFunc func(ctx context.Context) error
 
 func main() {
 	var (
@@ -76,7 +78,14 @@ func main() {
 		log.Fatalf("Error creating the DMG service: %v", err)
 	}
 
-	if err = service(); err != nil {
+	ctx, cancel := cmdutils.NewSignalContext()
+	defer cancel()
+	if ctx, err = cmdutils.ServeMetrics(ctx, httpAddress); err != nil {
+		log.Fatalf("Error starting the metrics server: %v", err)
+	}
+	err = service(ctx)
+	cmdutils.CloseProcessors(destroySession && err == nil)
+	if err != nil {
 		log.Fatalf("Error running the Mipmaps service: %v", err)
 	}
 }
@@ -90,7 +99,8 @@ func registerArgs() (fs *flag.FlagSet) {
 	fs.StringVar(&jobName, "jobName", "dmg", "Job name")
 	fs.StringVar(&accountID, "A", "", "Grid account id")
 	fs.BoolVar(&destroySession, "destroySession", false, "If true it destroyes the session when it's done if no errors have been encountered")
-	fs.StringVar(&mipmapsProcessorType, "mipmapsProcessor", "drmaa1", "Job processor type {echo, local, drmaa1, drmaa2}")
+	fs.StringVar(&mipmapsProcessorType, "mipmapsProcessor", "drmaa1", "Job processor type "+process.ProcessorTypes())
+	fs.StringVar(&httpAddress, "httpAddress", "", "Address (host:port) of the HTTP server that exposes /metrics and /jobs; no server is started if not set")
 	fs.BoolVar(&helpFlag, "h", false, "Display command line usage flags")
 	return fs
 }
@@ -117,10 +127,11 @@ func createMipmapsService(operation string,
 	if err != nil {
 		return nil, err
 	}
+	subjobProcessor := process.DecorateProcessor(mipmapsProcessor, process.RetryProcessing(resources))
 
 	switch operation {
 	case "retile":
-		return serviceFunc(func() error {
+		return serviceFunc(func(ctx context.Context) error {
 			var retileProcessor process.Processor
 			j := process.Job{
 				Name:  jobName,
@@ -133,12 +144,12 @@ func createMipmapsService(operation string,
 			} else {
 				j.Executable = resources.GetStringProperty("mipmapsExec")
 				j.Name = jobName + "_" + operation
-				retileProcessor = process.NewParallelProcessor(mipmapsProcessor, mipmaps.NewRetileJobSplitter(resources), resources)
+				retileProcessor = process.NewParallelProcessor(subjobProcessor, mipmaps.NewRetileJobSplitter(resources), resources)
 			}
-			return retileProcessor.Run(j)
+			return retileProcessor.Run(ctx, j)
 		}), nil
 	case "scale":
-		return serviceFunc(func() error {
+		return serviceFunc(func(ctx context.Context) error {
 			var scaleProcessor process.Processor
 			j := process.Job{
 				Name:  jobName,
@@ -151,31 +162,48 @@ func createMipmapsService(operation string,
 			} else {
 				j.Executable = resources.GetStringProperty("mipmapsExec")
 				j.Name = jobName + "_" + operation
-				scaleProcessor = process.NewParallelProcessor(mipmapsProcessor, mipmaps.NewRetileJobSplitter(resources), resources)
+				scaleProcessor = process.NewParallelProcessor(subjobProcessor, mipmaps.NewRetileJobSplitter(resources), resources)
 			}
-			return scaleProcessor.Run(j)
+			return scaleProcessor.Run(ctx, j)
 		}), nil
 	case "fullPyramid":
-		return serviceFunc(func() error {
-			var jobs []process.Job
+		return serviceFunc(func(ctx context.Context) error {
+			pipeline := process.NewJobGraph(jobName + "_" + operation)
 			retileCmdlineBuilder := mipmaps.NewServiceCmdlineBuilder("retile", mipmapsProcessorType, accountID, jobName, resources)
-			jobs = append(jobs, process.Job{
-				Executable:     resources.GetStringProperty("mipmapsExec"),
-				Name:           jobName,
-				JArgs:          args.Clone(),
-				CmdlineBuilder: retileCmdlineBuilder,
-			})
 			scaleCmdlineBuilder := mipmaps.NewServiceCmdlineBuilder("scale", mipmapsProcessorType, accountID, jobName, resources)
-			jobs = append(jobs, process.Job{
-				Executable:     resources.GetStringProperty("mipmapsExec"),
-				Name:           jobName,
-				JArgs:          args.Clone(),
-				CmdlineBuilder: scaleCmdlineBuilder,
-			})
-			return processPipelinedJobs(mipmapsProcessorType, resources, jobs)
+			zBlocks, err := mipmaps.ZBlockArgs(args, resources)
+			if err != nil {
+				return err
+			}
+			// every Z block is scaled as soon as it is retiled so different blocks run concurrently
+			for b, blockArgs := range zBlocks {
+				blockSuffix := ""
+				if len(zBlocks) > 1 {
+					blockSuffix = fmt.Sprintf("_z%d", b)
+				}
+				retileJob := process.Job{
+					Executable:     resources.GetStringProperty("mipmapsExec"),
+					Name:           jobName + "_retile" + blockSuffix,
+					JArgs:          blockArgs,
+					CmdlineBuilder: retileCmdlineBuilder,
+				}
+				if err := pipeline.AddJob(retileJob); err != nil {
+					return err
+				}
+				scaleJob := process.Job{
+					Executable:     resources.GetStringProperty("mipmapsExec"),
+					Name:           jobName + "_scale" + blockSuffix,
+					JArgs:          blockArgs.Clone(),
+					CmdlineBuilder: scaleCmdlineBuilder,
+				}
+				if err := pipeline.AddJob(scaleJob, retileJob.Name); err != nil {
+					return err
+				}
+			}
+			return processPipelinedJobs(ctx, mipmapsProcessorType, resources, pipeline)
 		}), nil
 	case "orthoviews": // this operation creates the scale level 0 for the XZ and ZY views; the operation assumes that the entire pyramid for XY exists
-		return serviceFunc(func() error {
+		return serviceFunc(func(ctx context.Context) error {
 			j := process.Job{
 				Executable: resources.GetStringProperty("mipmapsExec"),
 				Name:       jobName,
@@ -187,11 +215,11 @@ func createMipmapsService(operation string,
 				processorType: mipmapsProcessorType,
 				resources:     resources,
 			}
-			orthoviewsProcessor := process.NewParallelProcessor(mipmapsProcessor, jobSplitter, resources)
-			return orthoviewsProcessor.Run(j)
+			orthoviewsProcessor := process.NewParallelProcessor(subjobProcessor, jobSplitter, resources)
+			return orthoviewsProcessor.Run(ctx, j)
 		}), nil
 	case "fullOrthoviews": // this operation retiles and generates all scale levels for the XZ and ZY views; this assumes that the entire pyramid for XY exists
-		return serviceFunc(func() error {
+		return serviceFunc(func(ctx context.Context) error {
 			j := process.Job{
 				Executable: resources.GetStringProperty("mipmapsExec"),
 				Name:       jobName,
@@ -203,29 +231,51 @@ func createMipmapsService(operation string,
 				processorType: mipmapsProcessorType,
 				resources:     resources,
 			}
-			orthoviewsProcessor := process.NewParallelProcessor(mipmapsProcessor, jobSplitter, resources)
-			return orthoviewsProcessor.Run(j)
+			orthoviewsProcessor := process.NewParallelProcessor(subjobProcessor, jobSplitter, resources)
+			return orthoviewsProcessor.Run(ctx, j)
 		}), nil
 	case "allOrthoviews": // this operation retiles and generates all scale levels for all projections XY, XZ and ZY
-		return serviceFunc(func() error {
-			var jobs []process.Job
+		return serviceFunc(func(ctx context.Context) error {
+			pipeline := process.NewJobGraph(jobName + "_" + operation)
 			// first generate the full pyramid for xy
 			xyJobName := jobName + "_xy"
 			xyCmdlineBuilder := mipmaps.NewServiceCmdlineBuilder("fullPyramid", mipmapsProcessorType, accountID, xyJobName, resources)
-			jobs = append(jobs, process.Job{
+			xyJob := process.Job{
 				Executable:     resources.GetStringProperty("mipmapsExec"),
 				Name:           xyJobName,
 				JArgs:          mipmapsAttrs.GenerateXYArgs(args),
 				CmdlineBuilder: xyCmdlineBuilder,
-			})
-			fullOrthoviewsCmdlineBuilder := mipmaps.NewServiceCmdlineBuilder("fullOrthoviews", mipmapsProcessorType, accountID, jobName, resources)
-			jobs = append(jobs, process.Job{
-				Executable:     resources.GetStringProperty("mipmapsExec"),
-				Name:           jobName,
-				JArgs:          args.Clone(),
-				CmdlineBuilder: fullOrthoviewsCmdlineBuilder,
-			})
-			return processPipelinedJobs(mipmapsProcessorType, resources, jobs)
+			}
+			if err := pipeline.AddJob(xyJob); err != nil {
+				return err
+			}
+			// the XZ and ZY pyramids only depend on XY so they are generated concurrently
+			j := process.Job{
+				Executable: resources.GetStringProperty("mipmapsExec"),
+				Name:       jobName,
+				JArgs:      args.Clone(),
+			}
+			orthoviews := orthoviewsSplitter{
+				mipmapsAttrs:  mipmapsAttrs,
+				orthoViewOp:   "fullPyramid",
+				processorType: mipmapsProcessorType,
+				resources:     resources,
+			}
+			xzJob, err := orthoviews.createOrthoViewJob(j, "xz", mipmapsAttrs.GenerateXZArgs)
+			if err != nil {
+				return fmt.Errorf("Error creating the XZ orthoview job: %v", err)
+			}
+			if err = pipeline.AddJob(*xzJob, xyJob.Name); err != nil {
+				return err
+			}
+			zyJob, err := orthoviews.createOrthoViewJob(j, "zy", mipmapsAttrs.GenerateZYArgs)
+			if err != nil {
+				return fmt.Errorf("Error creating the ZY orthoview job: %v", err)
+			}
+			if err = pipeline.AddJob(*zyJob, xyJob.Name); err != nil {
+				return err
+			}
+			return processPipelinedJobs(ctx, mipmapsProcessorType, resources, pipeline)
 		}), nil
 	default:
 		return nil, fmt.Errorf("Unknown operation %s. Valid values are: retile | scale | fullPyramid | orthoviews | allOrthoviews | fullOrthoviews", operation)
@@ -233,7 +283,8 @@ func createMipmapsService(operation string,
 	return nil, err
 }
 
-func processPipelinedJobs(mipmapsProcessorType string, resources config.Config, jobs []process.Job) error {
+// processPipelinedJobs runs the pipeline's jobs as soon as their dependencies completed
+func processPipelinedJobs(ctx context.Context, mipmapsProcessorType string, resources config.Config, pipeline *process.JobGraph) error {
 	mipmapsProcessor, err := cmdutils.CreateProcessor(mipmapsProcessorType,
 		accountID,
 		sessionName,
@@ -244,12 +295,7 @@ func processPipelinedJobs(mipmapsProcessorType string, resources config.Config,
 	if err != nil {
 		return err
 	}
-	for _, job := range jobs {
-		if err := mipmapsProcessor.Run(job); err != nil {
-			return fmt.Errorf("Error encountered while processing job %s: %v", job.Name, err)
-		}
-	}
-	return nil
+	return process.ProcessJobGraph(ctx, mipmapsProcessor, pipeline, resources)
 }
 
 type orthoviewsSplitter struct {
@@ -1,12 +1,17 @@
 package cmdutils
 
 import (
+	"context"
 	"flag"
-	"fmt"
+	"log"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 
 	"config"
-	"drmaautils"
+	_ "drmaautils" // registers the grid processors
+	"metrics"
 	"process"
 )
 
@@ -21,29 +26,123 @@ func ParseArgs(fs *flag.FlagSet) error {
 	return fs.Parse(os.Args[1:])
 }
 
-// CreateProcessor create the job processor
+// NewSignalContext creates a context that is cancelled when the process receives an interrupt (Ctrl-C) or a SIGTERM.
+// A SIGUSR1 releases and a SIGUSR2 holds all the queued jobs of the processors created with CreateProcessor,
+// e.g., to drain the queue before a cluster maintenance window or to start a stage that was submitted held.
+func NewSignalContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, os.Interrupt, syscall.SIGTERM, syscall.SIGUSR1, syscall.SIGUSR2)
+	go func() {
+		defer signal.Stop(sigc)
+		for {
+			select {
+			case sig := <-sigc:
+				switch sig {
+				case syscall.SIGUSR1:
+					log.Printf("Received %v - release all held jobs", sig)
+					ControlJobs(process.Release)
+				case syscall.SIGUSR2:
+					log.Printf("Received %v - hold all queued jobs", sig)
+					ControlJobs(process.Hold)
+				default:
+					log.Printf("Received %v - cancel all running jobs", sig)
+					cancel()
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ctx, cancel
+}
+
+// ServeMetrics starts the metrics and the job status endpoints on the given address and returns a context
+// that feeds both of them with the events of all jobs processed with it.
+// If no address is given it returns the context unchanged.
+func ServeMetrics(ctx context.Context, httpAddress string) (context.Context, error) {
+	if httpAddress == "" {
+		return ctx, nil
+	}
+	board := process.NewJobStatusBoard()
+	if err := metrics.StartServer(httpAddress, board); err != nil {
+		return ctx, err
+	}
+	ctx = process.WithEventListener(ctx, board)
+	return process.WithEventListener(ctx, metrics.JobEventListener()), nil
+}
+
+// sessionCloser is implemented by the processors that must be closed when they are no longer used
+type sessionCloser interface {
+	CloseSession() error
+}
+
+var (
+	openProcessorsMu sync.Mutex
+	// openProcessors the processors created with CreateProcessor that must be closed
+	openProcessors []sessionCloser
+)
+
+// sessionDestroyer is implemented by the processors whose sessions can be destroyed when they are no longer needed
+type sessionDestroyer interface {
+	DestroySession() error
+}
+
+// CloseProcessors closes all the processors created with CreateProcessor, which, for example,
+// writes the grid accounting report. If destroySession is set the processors that support it
+// also destroy their sessions.
+func CloseProcessors(destroySession bool) {
+	openProcessorsMu.Lock()
+	defer openProcessorsMu.Unlock()
+	for _, p := range openProcessors {
+		if sd, ok := p.(sessionDestroyer); ok && destroySession {
+			if err := sd.DestroySession(); err != nil {
+				log.Printf("Error destroying the processor's session: %v", err)
+			}
+			continue
+		}
+		if err := p.CloseSession(); err != nil {
+			log.Printf("Error closing the processor: %v", err)
+		}
+	}
+	openProcessors = nil
+}
+
+// ControlJobs applies the action to the jobs of all the processors created with CreateProcessor
+// that can control their jobs
+func ControlJobs(action process.JobAction) {
+	openProcessorsMu.Lock()
+	defer openProcessorsMu.Unlock()
+	for _, p := range openProcessors {
+		if jc, ok := p.(process.JobController); ok {
+			if err := jc.ControlJobs(action); err != nil {
+				log.Printf("Error applying %s: %v", action, err)
+			}
+		}
+	}
+}
+
+// CreateProcessor create a job processor of one of the registered types. If localProcessorCtor
+// is not nil it replaces the default constructor of the "local" processor.
 func CreateProcessor(processorType, accountID, sessionName string,
 	localProcessorCtor func() (process.Processor, error),
 	resources config.Config) (process.Processor, error) {
 	var p process.Processor
 	var err error
-	switch processorType {
-	case "echo":
-		p = process.NewEchoProcessor()
-	case "local":
+	if processorType == "local" && localProcessorCtor != nil {
 		p, err = localProcessorCtor()
-	case "drmaa1":
-		p, err = drmaautils.NewGridProcessor(sessionName,
-			accountID,
-			drmaautils.NewDRMAAV1Proxy(),
-			resources)
-	case "drmaa2":
-		p, err = drmaautils.NewGridProcessor(sessionName,
-			accountID,
-			drmaautils.NewDRMAAV2Proxy(),
-			resources)
-	default:
-		err = fmt.Errorf("Invalid processor type: '%s'. Supported types are: {echo, local,drmaa1, drmaa2}", processorType)
+	} else {
+		p, err = process.NewProcessor(processorType, process.ProcessorParams{
+			AccountID:   accountID,
+			SessionName: sessionName,
+			Resources:   resources,
+		})
+	}
+	if sc, ok := p.(sessionCloser); ok && err == nil {
+		openProcessorsMu.Lock()
+		openProcessors = append(openProcessors, sc)
+		openProcessorsMu.Unlock()
 	}
 	return p, err
 }
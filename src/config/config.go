@@ -80,6 +80,40 @@ func (cfg Config) GetInt64Property(name string) (res int64) {
 	return 0
 }
 
+// GetFloat64Property get the property value as a float64; if the property does not exist
+// or if it's not a number it returns 0
+func (cfg Config) GetFloat64Property(name string) (res float64) {
+	if cfg[name] != nil {
+		switch v := cfg[name].(type) {
+		case int:
+			return float64(v)
+		case int32:
+			return float64(v)
+		case int64:
+			return float64(v)
+		case float64:
+			return v
+		default:
+			log.Printf("Expected float64 value for %s: %v", name, v)
+		}
+	}
+	return 0
+}
+
+// GetBoolProperty get the property value as a bool; if the property does not exist
+// or if it's not a bool it returns false
+func (cfg Config) GetBoolProperty(name string) (res bool) {
+	if cfg[name] != nil {
+		switch v := cfg[name].(type) {
+		case bool:
+			return v
+		default:
+			log.Printf("Expected bool value for %s: %v", name, v)
+		}
+	}
+	return false
+}
+
 // GetStringProperty - read a string property
 func (cfg Config) GetStringProperty(name string) (res string) {
 	defer func() {
@@ -133,3 +167,18 @@ func (cfg Config) GetStringMapProperty(name string) (res map[string]string) {
 	}
 	return res
 }
+
+// GetConfigProperty - read a nested settings object; if the property does not exist
+// or if it's not an object it returns an empty config
+func (cfg Config) GetConfigProperty(name string) Config {
+	switch v := cfg[name].(type) {
+	case Config:
+		return v
+	case map[string]interface{}:
+		return Config(v)
+	case nil:
+	default:
+		log.Printf("Expected object value for %s: %v", name, v)
+	}
+	return Config{}
+}
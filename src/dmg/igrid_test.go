@@ -68,3 +68,28 @@ func TestSplitAndMerge(t *testing.T) {
 	}
 	defer os.Remove(testOutput)
 }
+
+func TestSectionInputFilesAreListedOnce(t *testing.T) {
+	inputFiles, err := sectionInputFiles(testiGridFile, testiGridFile)
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	grid, err := readIGrid(testiGridFile)
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	seen := make(map[string]bool)
+	for _, f := range inputFiles {
+		if seen[f] {
+			t.Error("Input file listed more than once", f)
+		}
+		seen[f] = true
+	}
+	if len(inputFiles) < 2 || len(inputFiles) > len(grid.tiles)+1 || inputFiles[0] != testiGridFile {
+		t.Error("Unexpected input files", len(inputFiles), "for", len(grid.tiles), "tiles")
+	}
+
+	if _, err = sectionInputFiles("testdata/missing.iGrid"); err == nil {
+		t.Error("Expected an error for a missing iGrid")
+	}
+}
@@ -2,6 +2,8 @@ package dmg
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -16,11 +18,13 @@ import (
 )
 
 const (
-	pauseBetweenChecksInSec = 10
-	maxChecks               = 100
-	serverAddressPrefix     = "Server Address: "
+	maxChecks           = 100
+	serverAddressPrefix = "Server Address: "
 )
 
+// pauseBetweenChecks the interval between two reads of the DMG server's output
+var pauseBetweenChecks = 10 * time.Second
+
 // serverCmdlineBuilder - DMG server command line builder
 type serverCmdlineBuilder struct {
 }
@@ -108,26 +112,40 @@ func (pi imageBandsProcessingInfo) JobStderr() (io.ReadCloser, error) {
 }
 
 // WaitForTermination wait for job's completion
-func (pi imageBandsProcessingInfo) WaitForTermination() error {
+func (pi imageBandsProcessingInfo) WaitForTermination(ctx context.Context) error {
 	go func() {
 		if pi.serverJobInfo == nil {
 			log.Printf("No server job has been started")
 			return
 		}
-		if waitErr := pi.serverJobInfo.WaitForTermination(); waitErr != nil {
+		if waitErr := pi.serverJobInfo.WaitForTermination(ctx); waitErr != nil {
 			log.Printf("Error waiting for the DMG Server to terminate: %v", waitErr)
 		}
 	}()
 	if pi.clientJobInfo == nil {
 		return fmt.Errorf("No client job has been started")
 	}
-	if err := pi.clientJobInfo.WaitForTermination(); err != nil {
-		return fmt.Errorf("Error waiting for the DMG Client to terminate")
+	if err := pi.clientJobInfo.WaitForTermination(ctx); err != nil {
+		return fmt.Errorf("Error waiting for the DMG Client to terminate: %v", err)
 	}
 	log.Printf("DMG processing completed")
 	return nil
 }
 
+// Control applies the action to both the DMG server and the DMG client
+func (pi imageBandsProcessingInfo) Control(action process.JobAction) error {
+	var err error
+	for _, ji := range []process.Info{pi.clientJobInfo, pi.serverJobInfo} {
+		if ji == nil {
+			continue
+		}
+		if controlErr := ji.Control(action); controlErr != nil && err == nil {
+			err = controlErr
+		}
+	}
+	return err
+}
+
 // ImageBandsProcessor orchestrates the DMG client and server for one or multiple images
 type ImageBandsProcessor struct {
 	process.JobWatcher
@@ -136,16 +154,16 @@ type ImageBandsProcessor struct {
 }
 
 // Run the given job
-func (p ImageBandsProcessor) Run(j process.Job) error {
-	ji, err := p.Start(j)
+func (p ImageBandsProcessor) Run(ctx context.Context, j process.Job) error {
+	ji, err := p.Start(ctx, j)
 	if err != nil {
-		return fmt.Errorf("Error starting %v: %v", j, err)
+		return fmt.Errorf("Error starting %v: %w", j, err)
 	}
-	return p.Wait(ji)
+	return p.Wait(ctx, ji)
 }
 
 // Start the distributed gradient processing
-func (p ImageBandsProcessor) Start(j process.Job) (process.Info, error) {
+func (p ImageBandsProcessor) Start(ctx context.Context, j process.Job) (process.Info, error) {
 	var err error
 	var dmgAttrs Attrs
 
@@ -163,8 +181,10 @@ func (p ImageBandsProcessor) Start(j process.Job) (process.Info, error) {
 		Executable:     p.Resources.GetStringProperty("dmgServer"),
 		JArgs:          serverArgs,
 		CmdlineBuilder: serverCmdlineBuilder{},
+		// the server only coordinates the clients until they all finish
+		Requirements: process.ResourceRequirements{Role: "dmgServer", LongLived: true},
 	}
-	serverJobInfo, serverAddress, err := p.startDMGServer(serverJob)
+	serverJobInfo, serverAddress, err := p.startDMGServer(ctx, serverJob)
 	if err != nil {
 		return processInfo, err
 	}
@@ -177,12 +197,20 @@ func (p ImageBandsProcessor) Start(j process.Job) (process.Info, error) {
 		Executable:     p.Resources.GetStringProperty("dmgClient"),
 		JArgs:          clientArgs,
 		CmdlineBuilder: clientCmdlineBuilder{},
+		Requirements:   process.ResourceRequirements{Slots: dmgAttrs.nThreads, Role: "dmgClient"},
+		// each band's client reads and writes its files on the node's local disk
+		// if the processor stages them through a node-local "scratchDir"
+		Staging: process.FileStaging{
+			Inputs:     []string{"pixels", "labels"},
+			Outputs:    []string{"out"},
+			ScratchArg: "temp",
+		},
 	}
 	var clientJobSplitter imageBandSplitter
 	clientProcessor := process.NewParallelProcessor(p.ImageProcessor, clientJobSplitter, p.Resources)
 
 	log.Printf("Start DMG Client")
-	clientJobInfo, err := clientProcessor.Start(clientJob)
+	clientJobInfo, err := clientProcessor.Start(ctx, clientJob)
 	if err != nil {
 		return processInfo, fmt.Errorf("Error starting for the DMG Client: %v", err)
 	}
@@ -190,13 +218,9 @@ func (p ImageBandsProcessor) Start(j process.Job) (process.Info, error) {
 	return processInfo, nil
 }
 
-func (p ImageBandsProcessor) startDMGServer(j process.Job) (process.Info, string, error) {
+func (p ImageBandsProcessor) startDMGServer(ctx context.Context, j process.Job) (process.Info, string, error) {
 	log.Printf("Start DMG Server")
-	jobInfo, err := p.ImageProcessor.Start(j)
-	if err != nil {
-		return jobInfo, "", err
-	}
-	jobOutput, err := jobInfo.JobStdout()
+	jobInfo, err := p.ImageProcessor.Start(ctx, j)
 	if err != nil {
 		return jobInfo, "", err
 	}
@@ -207,29 +231,54 @@ func (p ImageBandsProcessor) startDMGServer(j process.Job) (process.Info, string
 	if serverAddress != "" {
 		return jobInfo, serverAddress, nil
 	}
+	jobOutput, err := jobInfo.JobStdout()
+	if errors.Is(err, process.ErrScriptedJob) {
+		// the clients cannot find out where a server that only runs later from the script listens
+		return jobInfo, "", fmt.Errorf("The DMG server address must be set with serverAddress when the jobs are only written to a script: %w", err)
+	} else if err != nil {
+		return jobInfo, "", err
+	}
 	r := bufio.NewReader(jobOutput)
+	// partialLine holds the start of a line whose end was not written yet
+	var partialLine string
 	for i := 0; i < maxChecks; i++ {
 		line, err := r.ReadString('\n')
 		if err != nil {
 			if err == io.EOF {
-				// there was no new output since the last read
-				time.Sleep(pauseBetweenChecksInSec * time.Second)
+				// there was no complete line since the last read
+				partialLine += line
+				if err = sleepOrCancel(ctx, pauseBetweenChecks); err != nil {
+					return jobInfo, "", err
+				}
 				continue
 			}
 			// there was some other error than EOF so stop here
 			return jobInfo, "", err
 		}
-		line = strings.TrimSpace(line)
+		line = strings.TrimSpace(partialLine + line)
+		partialLine = ""
 		if strings.HasPrefix(line, serverAddressPrefix) {
-			serverAddress := strings.TrimLeft(line, serverAddressPrefix)
+			serverAddress := strings.TrimPrefix(line, serverAddressPrefix)
 			log.Printf("Server started on %s:", serverAddress)
 			return jobInfo, serverAddress, nil
 		}
-		time.Sleep(pauseBetweenChecksInSec * time.Second)
+		if err = sleepOrCancel(ctx, pauseBetweenChecks); err != nil {
+			return jobInfo, "", err
+		}
 	}
 	return jobInfo, "", fmt.Errorf("Timeout - could not read server's address")
 }
 
+// sleepOrCancel pauses for the given duration and returns early with an error if the context is cancelled
+func sleepOrCancel(ctx context.Context, d time.Duration) error {
+	select {
+	case <-time.After(d):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 // imageBandSplitter - splits the job based on the number of entries in the pixels and labels list.
 // Each entry typically corresponds to an image band and all bands are solved on the same server instance.
 // The number of entries in the pixels list must be equal to the number of entries in the labels list and
@@ -237,6 +286,12 @@ func (p ImageBandsProcessor) startDMGServer(j process.Job) (process.Info, string
 type imageBandSplitter struct {
 }
 
+// Resumable returns false because all bands must connect to the same DMG server
+// so none of them can be skipped even if it completed in a previous run.
+func (s imageBandSplitter) Resumable() bool {
+	return false
+}
+
 // SplitJob splits the job into multiple parallelizable jobs
 func (s imageBandSplitter) SplitJob(j process.Job, jch chan<- process.Job) error {
 	var err error
@@ -286,5 +341,7 @@ func (s imageBandSplitter) createJob(j process.Job, jobIndex int, pixels, labels
 		Name:           fmt.Sprintf("%s_%d", j.Name, jobIndex),
 		JArgs:          newJobArgs,
 		CmdlineBuilder: j.CmdlineBuilder,
+		Requirements:   j.Requirements,
+		Staging:        j.Staging,
 	}, nil
 }
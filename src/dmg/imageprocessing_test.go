@@ -0,0 +1,135 @@
+package dmg
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"arg"
+	"config"
+	"process"
+)
+
+// chunkedOutput returns the chunks one per read with an EOF after every chunk,
+// like a log file that the job is still writing
+type chunkedOutput struct {
+	chunks []string
+	eof    bool
+}
+
+func (o *chunkedOutput) Read(b []byte) (int, error) {
+	if o.eof || len(o.chunks) == 0 {
+		o.eof = false
+		return 0, io.EOF
+	}
+	n := copy(b, o.chunks[0])
+	o.chunks = o.chunks[1:]
+	o.eof = true
+	return n, nil
+}
+
+// serverJobInfo the info of a DMG server job that writes its output in chunks
+type serverJobInfo struct {
+	output *chunkedOutput
+}
+
+func (ji serverJobInfo) JobStdout() (io.ReadCloser, error) {
+	return ioutil.NopCloser(ji.output), nil
+}
+
+func (ji serverJobInfo) JobStderr() (io.ReadCloser, error) {
+	return ioutil.NopCloser(strings.NewReader("")), nil
+}
+
+func (ji serverJobInfo) WaitForTermination(ctx context.Context) error {
+	return nil
+}
+
+func (ji serverJobInfo) Control(action process.JobAction) error {
+	return nil
+}
+
+// serverProcessor starts DMG server jobs that write the given output chunks
+type serverProcessor struct {
+	process.JobWatcher
+	chunks []string
+}
+
+func (p serverProcessor) Start(ctx context.Context, j process.Job) (process.Info, error) {
+	return serverJobInfo{&chunkedOutput{chunks: p.chunks}}, nil
+}
+
+func (p serverProcessor) Run(ctx context.Context, j process.Job) error {
+	return nil
+}
+
+func TestStartDMGServerReadsAddressWrittenInChunks(t *testing.T) {
+	defer func(pause time.Duration) { pauseBetweenChecks = pause }(pauseBetweenChecks)
+	pauseBetweenChecks = time.Millisecond
+
+	p := ImageBandsProcessor{
+		ImageProcessor: serverProcessor{chunks: []string{"Starting\nServer Addr", "ess: server05", ":1234\n"}},
+	}
+	serverJob := process.Job{Name: "server", JArgs: *arg.NewArgs(&Attrs{})}
+	_, serverAddress, err := p.startDMGServer(context.Background(), serverJob)
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if serverAddress != "server05:1234" {
+		t.Errorf("Expected server05:1234 but got %q", serverAddress)
+	}
+}
+
+func TestScriptedDMGImageRunsFromTheScript(t *testing.T) {
+	scriptDir, err := ioutil.TempDir("", "dmgscript")
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	defer os.RemoveAll(scriptDir)
+	// the server only exits once the client ran so the script hangs if it waits for the server first
+	server := filepath.Join(scriptDir, "server.sh")
+	client := filepath.Join(scriptDir, "client.sh")
+	if err = ioutil.WriteFile(server, []byte("#!/bin/bash\nwhile [ ! -e client.done ]; do sleep 0.1; done\ntouch server.done\n"), 0775); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if err = ioutil.WriteFile(client, []byte("#!/bin/bash\ntouch client.done\n"), 0775); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	resources := config.Config{"dmgServer": server, "dmgClient": client, "scriptDir": scriptDir, "workingDir": scriptDir}
+	sp, err := process.NewScriptProcessor("dmg", resources)
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	p := ImageBandsProcessor{ImageProcessor: sp, Resources: resources}
+	j := process.Job{Name: "image", JArgs: *arg.NewArgs(&Attrs{})}
+	j.JArgs.UpdateStringArg("pixels", "pixels.iGrid")
+	j.JArgs.UpdateStringArg("labels", "labels.iGrid")
+	j.JArgs.UpdateStringArg("out", "out.iGrid")
+	if err = p.Run(context.Background(), j); !errors.Is(err, process.ErrScriptedJob) {
+		t.Fatal("Expected the scripted server to require its address but got", err)
+	}
+
+	j.JArgs.UpdateStringArg("serverAddress", "localhost:1234")
+	if err = p.Run(context.Background(), j); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if err = sp.(interface{ CloseSession() error }).CloseSession(); err != nil {
+		t.Fatal("Unexpected error closing the script", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if output, err := exec.CommandContext(ctx, "bash", filepath.Join(scriptDir, "dmg.sh")).CombinedOutput(); err != nil {
+		t.Fatal("Unexpected error running the script", err, string(output))
+	}
+	if _, err = os.Stat(filepath.Join(scriptDir, "server.done")); err != nil {
+		t.Error("Expected the script to wait for the server", err)
+	}
+}
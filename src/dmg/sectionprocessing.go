@@ -1,6 +1,7 @@
 package dmg
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -8,6 +9,7 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -41,14 +43,22 @@ func (sj sectionJobInfo) JobStderr() (io.ReadCloser, error) {
 }
 
 // WaitForTermination wait for job's completion
-func (sj sectionJobInfo) WaitForTermination() error {
+func (sj sectionJobInfo) WaitForTermination(ctx context.Context) error {
 	var sectionHelper SectionHelper
+	if err := sj.dmgProcessInfo.WaitForTermination(ctx); err != nil {
+		return err
+	}
 	if err := sectionHelper.CreateSectionJobResults(sj.sectionArgs, sj.resources); err != nil {
 		return err
 	}
 	return nil
 }
 
+// Control applies the action to the section's DMG job
+func (sj sectionJobInfo) Control(action process.JobAction) error {
+	return sj.dmgProcessInfo.Control(action)
+}
+
 // SectionProcessor - section processor
 type SectionProcessor struct {
 	process.JobWatcher
@@ -58,16 +68,16 @@ type SectionProcessor struct {
 }
 
 // Run the given job
-func (sp SectionProcessor) Run(j process.Job) error {
-	ji, err := sp.Start(j)
+func (sp SectionProcessor) Run(ctx context.Context, j process.Job) error {
+	ji, err := sp.Start(ctx, j)
 	if err != nil {
-		return fmt.Errorf("Error starting %v: %v", j, err)
+		return fmt.Errorf("Error starting %v: %w", j, err)
 	}
-	return sp.Wait(ji)
+	return sp.Wait(ctx, ji)
 }
 
 // Start launches the server
-func (sp SectionProcessor) Start(j process.Job) (process.Info, error) {
+func (sp SectionProcessor) Start(ctx context.Context, j process.Job) (process.Info, error) {
 	var sectionHelper SectionHelper
 	sectionArgs, err := sectionHelper.PrepareSectionJobArgs(&j.JArgs, sp.Resources)
 	if err != nil {
@@ -83,7 +93,7 @@ func (sp SectionProcessor) Start(j process.Job) (process.Info, error) {
 			SectionProcessorType: "local",
 		},
 	}
-	dmgProcessInfo, err := sp.ImageProcessor.Start(sj)
+	dmgProcessInfo, err := sp.ImageProcessor.Start(ctx, sj)
 	if err != nil {
 		return nil, err
 	}
@@ -321,15 +331,42 @@ func (s SectionHelper) PrepareSectionJobArgs(args *arg.Args, resources config.Co
 func readIGrid(filename string) (*iGrid, error) {
 	log.Printf("Read iGrid %s", filename)
 	gr, err := open(filename)
-	defer func() {
-		gr.close()
-	}()
 	if err != nil {
 		return nil, err
 	}
+	defer func() {
+		gr.close()
+	}()
 	return gr.read()
 }
 
+// sectionInputFiles returns the given iGrid files together with all the distinct tiles they reference
+// sorted by name
+func sectionInputFiles(iGridFiles ...string) ([]string, error) {
+	var inputFiles, tileFiles []string
+	seen := make(map[string]bool)
+	for _, iGridFile := range iGridFiles {
+		if iGridFile == "" || seen[iGridFile] {
+			continue
+		}
+		seen[iGridFile] = true
+		inputFiles = append(inputFiles, iGridFile)
+		g, err := readIGrid(iGridFile)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading the tiles of %s: %v", iGridFile, err)
+		}
+		for _, t := range g.tiles {
+			if t.name == "" || seen[t.name] {
+				continue
+			}
+			seen[t.name] = true
+			tileFiles = append(tileFiles, t.name)
+		}
+	}
+	sort.Strings(tileFiles)
+	return append(inputFiles, tileFiles...), nil
+}
+
 func splitGrid(g *iGrid, nSections int) []*iGrid {
 	sections := make([]*iGrid, nSections)
 
@@ -456,8 +493,10 @@ func (s ZSplitter) SplitJob(j process.Job, jch chan<- process.Job) error {
 	for z := minZ; z <= maxZ; z++ {
 		newJobArgs := j.JArgs.Clone()
 
-		newJobArgs.UpdateStringArg("pixels", strings.Replace(dmgAttrs.sourcePixels, "{z}", strconv.FormatInt(z, 10), -1))
-		newJobArgs.UpdateStringArg("labels", strings.Replace(dmgAttrs.sourceLabels, "{z}", strconv.FormatInt(z, 10), -1))
+		pixels := strings.Replace(dmgAttrs.sourcePixels, "{z}", strconv.FormatInt(z, 10), -1)
+		labels := strings.Replace(dmgAttrs.sourceLabels, "{z}", strconv.FormatInt(z, 10), -1)
+		newJobArgs.UpdateStringArg("pixels", pixels)
+		newJobArgs.UpdateStringArg("labels", labels)
 		newJobArgs.UpdateStringArg("targetDir", strings.Replace(dmgAttrs.targetDir, "{z}", strconv.FormatInt(z, 10), -1))
 
 		newJob := process.Job{
@@ -465,6 +504,9 @@ func (s ZSplitter) SplitJob(j process.Job, jch chan<- process.Job) error {
 			Name:           fmt.Sprintf("%s_%d", j.Name, z),
 			JArgs:          newJobArgs,
 			CmdlineBuilder: j.CmdlineBuilder,
+			// the iGrids are only read if the jobs are cached
+			ListInputFiles: func() ([]string, error) { return sectionInputFiles(pixels, labels) },
+			Requirements:   process.ResourceRequirements{Role: "dmgSection"},
 		}
 		jch <- newJob
 	}
@@ -0,0 +1,164 @@
+package drmaautils
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// accountingRecord the resources used by a grid job
+type accountingRecord struct {
+	JobName          string    `json:"jobName"`
+	JobID            string    `json:"jobId"`
+	Account          string    `json:"account"`
+	Queue            string    `json:"queue"`
+	Hosts            []string  `json:"hosts"`
+	Slots            int64     `json:"slots"`
+	State            string    `json:"state"`
+	ExitStatus       int       `json:"exitStatus"`
+	SubmissionTime   time.Time `json:"submissionTime"`
+	DispatchTime     time.Time `json:"dispatchTime"`
+	FinishTime       time.Time `json:"finishTime"`
+	WallclockSeconds float64   `json:"wallclockSeconds"`
+	CPUSeconds       int64     `json:"cpuSeconds"`
+}
+
+// accountingReport collects the resources used by all the jobs of a session
+type accountingReport struct {
+	mu      sync.Mutex
+	name    string
+	records []accountingRecord
+}
+
+var (
+	accountingReportsMu sync.Mutex
+	// accountingReports the reports of all sessions indexed by the session name
+	accountingReports = make(map[string]*accountingReport)
+)
+
+// sessionAccounting returns the report shared by all processors that use the named session
+func sessionAccounting(sessionName string) *accountingReport {
+	accountingReportsMu.Lock()
+	defer accountingReportsMu.Unlock()
+	ar, found := accountingReports[sessionName]
+	if !found {
+		ar = &accountingReport{name: sessionName}
+		accountingReports[sessionName] = ar
+	}
+	return ar
+}
+
+// record adds the job's resource usage to the report
+func (ar *accountingReport) record(jobName, account string, ji *JobInfo) {
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+	ar.records = append(ar.records, accountingRecord{
+		JobName:          jobName,
+		JobID:            ji.ID,
+		Account:          account,
+		Queue:            ji.QueueName,
+		Hosts:            ji.AllocatedMachines,
+		Slots:            ji.Slots,
+		State:            ji.State.String(),
+		ExitStatus:       ji.ExitStatus,
+		SubmissionTime:   ji.SubmissionTime,
+		DispatchTime:     ji.DispatchTime,
+		FinishTime:       ji.FinishTime,
+		WallclockSeconds: ji.WallclockTime.Seconds(),
+		CPUSeconds:       ji.CPUTime,
+	})
+}
+
+// write saves the report as <name>-accounting.json and <name>-accounting.csv in the given directory
+func (ar *accountingReport) write(reportDir string) error {
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+	if len(ar.records) == 0 {
+		return nil
+	}
+	if err := os.MkdirAll(reportDir, 0775); err != nil {
+		return fmt.Errorf("Error creating the report directory %s: %v", reportDir, err)
+	}
+	reportJSON, err := json.MarshalIndent(ar.records, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Error encoding the accounting report for %s: %v", ar.name, err)
+	}
+	jsonFile := filepath.Join(reportDir, ar.name+"-accounting.json")
+	if err = ioutil.WriteFile(jsonFile, reportJSON, 0664); err != nil {
+		return fmt.Errorf("Error writing the accounting report %s: %v", jsonFile, err)
+	}
+	csvFile := filepath.Join(reportDir, ar.name+"-accounting.csv")
+	if err = ar.writeCSV(csvFile); err != nil {
+		return fmt.Errorf("Error writing the accounting report %s: %v", csvFile, err)
+	}
+	var cpuSeconds int64
+	var slotSeconds float64
+	for _, r := range ar.records {
+		cpuSeconds += r.CPUSeconds
+		slots := r.Slots
+		if slots <= 0 {
+			slots = 1
+		}
+		slotSeconds += float64(slots) * r.WallclockSeconds
+	}
+	log.Printf("Session %s used %.2f CPU hours and %.2f slot hours in %d jobs - accounting written to %s",
+		ar.name, float64(cpuSeconds)/3600, slotSeconds/3600, len(ar.records), csvFile)
+	return nil
+}
+
+func (ar *accountingReport) writeCSV(csvFile string) error {
+	f, err := os.Create(csvFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := csv.NewWriter(f)
+	w.Write([]string{"jobName", "jobId", "account", "queue", "hosts", "slots", "state", "exitStatus",
+		"submissionTime", "dispatchTime", "finishTime", "wallclockSeconds", "cpuSeconds"})
+	for _, r := range ar.records {
+		w.Write([]string{
+			r.JobName,
+			r.JobID,
+			r.Account,
+			r.Queue,
+			strings.Join(r.Hosts, " "),
+			strconv.FormatInt(r.Slots, 10),
+			r.State,
+			strconv.Itoa(r.ExitStatus),
+			formatAccountingTime(r.SubmissionTime),
+			formatAccountingTime(r.DispatchTime),
+			formatAccountingTime(r.FinishTime),
+			strconv.FormatFloat(r.WallclockSeconds, 'f', 3, 64),
+			strconv.FormatInt(r.CPUSeconds, 10),
+		})
+	}
+	w.Flush()
+	return w.Error()
+}
+
+func formatAccountingTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+// executionHosts looks up the hosts that ran a finished job in the UGE accounting (qacct) for the sessions that
+// do not report them, e.g., DRMAA v1. The hosts remain unknown if the accounting is not available, for example
+// on a grid engine other than UGE, or if the job's record has not been written yet.
+func executionHosts(id string) []string {
+	output, err := runSchedulerCmd(UGECLI{}.FinishedCmd(id))
+	if err != nil {
+		return nil
+	}
+	ji, _ := UGECLI{}.ParseFinished(output)
+	return ji.AllocatedMachines
+}
@@ -0,0 +1,207 @@
+package drmaautils
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+
+	"process"
+)
+
+// StartBulk submits the jobs as array jobs. Every task runs a generated task script that selects
+// the task's command from the task index set by the grid. The jobs with the same requirements are submitted
+// together as a single array job so a batch of jobs with different requirements results in one array job per requirements.
+// The task scripts are written to "taskDir" or, if it is not set, to the working directory.
+// The tasks that stage files through the node-local "scratchDir" run their own staging script
+// unless the session transfers the staged files itself.
+// The jobs that a previous driver already submitted are reattached instead of being added to an array job.
+func (p *GridProcessor) StartBulk(ctx context.Context, name string, jobs []process.Job) ([]process.Info, error) {
+	bs, ok := p.js.(DRMAABulkSession)
+	if !ok {
+		return nil, fmt.Errorf("Session %s does not support bulk jobs", p.sessionName)
+	}
+	infos := make([]process.Info, len(jobs))
+	fingerprints := make([]string, len(jobs))
+	var pending []JobTemplate
+	var pendingIndexes []int
+	for i, j := range jobs {
+		jt, err := p.jobTemplate(j)
+		if err != nil {
+			return nil, err
+		}
+		fingerprints[i] = templateFingerprint(jt)
+		if jobInfo, found := p.reattach(jt, fingerprints[i]); found {
+			infos[i] = p.submitted(jt, jobInfo, process.NewJobTracker(ctx, p.processorType, j.Name), fingerprints[i], "")
+			continue
+		}
+		pending = append(pending, jt)
+		pendingIndexes = append(pendingIndexes, i)
+	}
+	groups := groupByRequirements(pending, p.sessionStagesFiles())
+	var submitted []process.Info
+	for g, group := range groups {
+		arrayJobName := name
+		if len(groups) > 1 {
+			arrayJobName = fmt.Sprintf("%s_%d", name, g+1)
+		}
+		templates := make([]JobTemplate, len(group))
+		for t, pi := range group {
+			templates[t] = pending[pi]
+		}
+		jt, tasks, stagingScripts, err := p.runArrayJob(bs, arrayJobName, templates)
+		if err != nil {
+			// the jobs are submitted all or none so stop the array jobs that were already submitted
+			p.terminateSubmitted(submitted, err)
+			return nil, err
+		}
+		for t, task := range tasks {
+			i := pendingIndexes[group[t]]
+			taskTemplate := jt
+			taskTemplate.JobName = jobs[i].Name
+			infos[i] = p.submitted(taskTemplate, task, process.NewJobTracker(ctx, p.processorType, jobs[i].Name), fingerprints[i], stagingScripts[t])
+			submitted = append(submitted, infos[i])
+		}
+	}
+	return infos, nil
+}
+
+// runArrayJob submits the templates, which must have the same requirements, as the tasks of a single array job
+// and returns the array job's template together with the info and the staging script, if any, of every task
+func (p *GridProcessor) runArrayJob(bs DRMAABulkSession, name string, templates []JobTemplate) (JobTemplate, []*JobInfo, []string, error) {
+	jt := templates[0]
+	taskDir := p.resources.GetStringProperty("taskDir")
+	if taskDir == "" {
+		taskDir = jt.WorkingDirectory
+	}
+	stagingScripts := make([]string, len(templates))
+	removeStagingScripts := func() {
+		for _, stagingScript := range stagingScripts {
+			removeStagingScript(stagingScript, nil)
+		}
+	}
+	for t := range templates {
+		var err error
+		if stagingScripts[t], err = p.stageThroughScript(&templates[t]); err != nil {
+			removeStagingScripts()
+			return jt, nil, nil, err
+		}
+	}
+	taskScript, err := writeTaskScript(taskDir, name, templates)
+	if err != nil {
+		removeStagingScripts()
+		return jt, nil, nil, err
+	}
+	jt.JobName = name
+	jt.RemoteCommand = "/bin/sh"
+	jt.Args = []string{taskScript}
+	if !p.sessionStagesFiles() {
+		// every task stages its own files
+		jt.StageInFiles = nil
+		jt.StageOutFiles = nil
+	}
+
+	log.Printf("Submit (%d-%d) %s with %d tasks from %s", jt.MinSlots, jt.MaxSlots, name, len(templates), taskScript)
+	tasks, err := bs.RunBulkJobs(jt, 1, len(templates), 1)
+	if err != nil {
+		removeStagingScripts()
+		return jt, nil, nil, &SchedulerError{Op: "submitting " + name, Err: err}
+	}
+	if len(tasks) != len(templates) {
+		return jt, nil, nil, fmt.Errorf("Expected %d tasks for %s but the grid created %d", len(templates), name, len(tasks))
+	}
+	log.Printf("Submitted bulk job %s", name)
+	return jt, tasks, stagingScripts, nil
+}
+
+// groupByRequirements groups the templates that can run as tasks of the same array job and returns
+// the indexes of the templates of every group in the order of their first template. If the session
+// transfers the staged files itself only the templates that stage the same files can be grouped
+// since the files are staged by the array job's template.
+func groupByRequirements(templates []JobTemplate, sameStaging bool) [][]int {
+	var groups [][]int
+	groupIndexes := make(map[string]int)
+	for i, jt := range templates {
+		key := requirementsKey(jt, sameStaging)
+		g, found := groupIndexes[key]
+		if !found {
+			g = len(groups)
+			groupIndexes[key] = g
+			groups = append(groups, nil)
+		}
+		groups[g] = append(groups[g], i)
+	}
+	return groups
+}
+
+// requirementsKey returns the settings of the template that do not depend on the task's command
+// and, unless withStaging is set, on the task's staged files
+func requirementsKey(jt JobTemplate, withStaging bool) string {
+	jt.JobName = ""
+	jt.RemoteCommand = ""
+	jt.Args = nil
+	if !withStaging {
+		jt.StageInFiles = nil
+		jt.StageOutFiles = nil
+	}
+	// the template only holds plain values so encoding it cannot fail
+	key, _ := json.Marshal(struct {
+		Template   JobTemplate
+		Extensions map[string]string
+	}{jt, jt.ExtensionList})
+	return string(key)
+}
+
+// terminateSubmitted terminates the tasks that were submitted before the bulk submission failed
+func (p *GridProcessor) terminateSubmitted(infos []process.Info, err error) {
+	for _, ji := range infos {
+		gji := ji.(GridJobInfo)
+		if controlErr := p.js.ControlJob(gji.jobInfo, process.Terminate); controlErr != nil {
+			log.Printf("Error terminating job %s: %v", gji.jobInfo.ID, controlErr)
+		}
+		p.active.remove(gji.jobInfo.ID)
+		p.state.finished(gji.jt.JobName, gji.jobInfo.ID)
+		gji.tracker.Done(err)
+	}
+}
+
+// writeTaskScript writes a script that runs the command of the job template selected by the task index.
+// The task index is set by UGE in SGE_TASK_ID, by Slurm in SLURM_ARRAY_TASK_ID and by LSF in LSB_JOBINDEX.
+func writeTaskScript(taskDir, name string, templates []JobTemplate) (string, error) {
+	var script bytes.Buffer
+	script.WriteString("#!/bin/sh\n")
+	fmt.Fprintf(&script, "# %s - %d tasks\n", name, len(templates))
+	script.WriteString("task=${SGE_TASK_ID:-${SLURM_ARRAY_TASK_ID:-$LSB_JOBINDEX}}\n")
+	script.WriteString("case \"$task\" in\n")
+	for i, jt := range templates {
+		args := make([]string, 0, len(jt.Args)+1)
+		args = append(args, process.ShellQuote(jt.RemoteCommand))
+		for _, a := range jt.Args {
+			args = append(args, process.ShellQuote(a))
+		}
+		fmt.Fprintf(&script, "%d) # %s\n  exec %s ;;\n", i+1, jt.JobName, strings.Join(args, " "))
+	}
+	script.WriteString("*)\n  echo \"Invalid task $task\" >&2\n  exit 1 ;;\nesac\n")
+
+	if err := os.MkdirAll(taskDir, 0775); err != nil {
+		return "", fmt.Errorf("Error creating the task directory %s: %v", taskDir, err)
+	}
+	// every submission gets its own script because the queued tasks of an array job
+	// that was submitted before a restart still read their script when they start
+	f, err := ioutil.TempFile(taskDir, name+".*.tasks.sh")
+	if err != nil {
+		return "", fmt.Errorf("Error creating the task script for %s in %s: %v", name, taskDir, err)
+	}
+	defer f.Close()
+	if _, err = f.Write(script.Bytes()); err != nil {
+		return "", fmt.Errorf("Error writing the task script %s: %v", f.Name(), err)
+	}
+	if err = f.Chmod(0775); err != nil {
+		return "", fmt.Errorf("Error making the task script %s executable: %v", f.Name(), err)
+	}
+	return f.Name(), nil
+}
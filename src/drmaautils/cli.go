@@ -0,0 +1,229 @@
+package drmaautils
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"process"
+)
+
+// SchedulerCLI translates job templates and job operations into the commands of a scheduler's command line tools
+type SchedulerCLI interface {
+	// SubmitCmd returns the command that submits the job template
+	SubmitCmd(jt JobTemplate) []string
+	// ParseJobID extracts the job ID from the output of the submit command
+	ParseJobID(output string) (string, error)
+	// StatusCmd returns the command that reports the state of the given jobs
+	StatusCmd(ids []string) []string
+	// ParseStatus extracts the state of the jobs indexed by the job ID from the output of the status command
+	ParseStatus(output string) map[string]JobInfo
+	// ControlCmd returns the command that applies the action to the job
+	ControlCmd(id string, action process.JobAction) ([]string, error)
+}
+
+// FinishedJobCLI is implemented by the schedulers whose status command only reports the jobs
+// that have not finished yet; the finished jobs are then looked up in the scheduler's accounting.
+type FinishedJobCLI interface {
+	// FinishedCmd returns the command that reports the accounting of the finished job
+	FinishedCmd(id string) []string
+	// ParseFinished extracts the final state of the job from the output of the finished command;
+	// it returns false if the job is not in the accounting yet
+	ParseFinished(output string) (JobInfo, bool)
+}
+
+// CLIProxy - DRMAA proxy that drives a scheduler through its command line tools so it does not need libdrmaa
+type CLIProxy struct {
+	name string
+	cli  SchedulerCLI
+}
+
+// maxUnreportedPolls the number of consecutive polls after which a job that the scheduler
+// reports neither in its status nor in its accounting is considered lost
+var maxUnreportedPolls = 30
+
+// CLISession - session of a command line proxy. The schedulers have no notion of sessions
+// so all the sessions of a proxy see the same jobs.
+type CLISession struct {
+	cli SchedulerCLI
+	mu  sync.Mutex
+	// unreported the number of consecutive polls that did not find the job indexed by the job ID
+	unreported map[string]int
+}
+
+// NewCLIProxy creates a command line proxy registered as the given processor type
+func NewCLIProxy(name string, cli SchedulerCLI) DRMAAProxy {
+	return &CLIProxy{name: name, cli: cli}
+}
+
+func init() {
+	for name, cli := range map[string]SchedulerCLI{
+		"qsub":   UGECLI{},
+		"sbatch": SlurmCLI{},
+		"bsub":   LSFCLI{},
+	} {
+		proxyName, schedulerCLI := name, cli
+		process.RegisterProcessor(proxyName, func(params process.ProcessorParams) (process.Processor, error) {
+			return NewGridProcessor(params.SessionName, params.AccountID, NewCLIProxy(proxyName, schedulerCLI), params.Resources)
+		})
+	}
+}
+
+func (cp *CLIProxy) processorType() string {
+	return cp.name
+}
+
+// CreateSession DRMAAProxy method
+func (cp *CLIProxy) CreateSession(name string) (DRMAASession, error) {
+	return &CLISession{cli: cp.cli, unreported: make(map[string]int)}, nil
+}
+
+// RunJob DRMAASession method
+func (cs *CLISession) RunJob(jt JobTemplate) (*JobInfo, error) {
+	for _, logDir := range []string{jt.OutputPath, jt.ErrorPath} {
+		if logDir == "" {
+			continue
+		}
+		if err := os.MkdirAll(logDir, os.ModePerm); err != nil {
+			return nil, fmt.Errorf("Error creating log directory %v: %v", logDir, err)
+		}
+	}
+	output, err := runSchedulerCmd(cs.cli.SubmitCmd(jt))
+	if err != nil {
+		return nil, fmt.Errorf("Error submitting job %v", err)
+	}
+	jobID, err := cs.cli.ParseJobID(output)
+	if err != nil {
+		return nil, err
+	}
+	return &JobInfo{ID: jobID}, nil
+}
+
+// UpdateJobInfo DRMAASession method
+func (cs *CLISession) UpdateJobInfo(j *JobInfo) error {
+	return cs.UpdateJobsInfo([]*JobInfo{j})[0]
+}
+
+// UpdateJobsInfo DRMAABatchSession method. It retrieves the state of all the jobs with a single status command.
+// A job that the scheduler does not report is Undetermined until it shows up in the status or in the accounting;
+// if it does not show up for maxUnreportedPolls consecutive polls its update fails.
+func (cs *CLISession) UpdateJobsInfo(jobs []*JobInfo) []error {
+	errs := make([]error, len(jobs))
+	ids := make([]string, len(jobs))
+	for i, j := range jobs {
+		ids[i] = j.ID
+	}
+	output, err := runSchedulerCmd(cs.cli.StatusCmd(ids))
+	if err != nil && output == "" {
+		for i := range jobs {
+			errs[i] = err
+		}
+		return errs
+	}
+	statuses := cs.cli.ParseStatus(output)
+	for i, j := range jobs {
+		status, found := statuses[j.ID]
+		if !found && cs.accountingDue(j.ID) {
+			status, found = cs.finishedJob(j.ID)
+		}
+		if !found {
+			j.State = Undetermined
+			errs[i] = cs.unreportedJob(j.ID)
+			continue
+		}
+		cs.reportedJob(j.ID)
+		updateFromCLIStatus(j, status)
+	}
+	return errs
+}
+
+// unreportedJob counts the consecutive polls that did not find the job and returns an error once there are too many
+func (cs *CLISession) unreportedJob(id string) error {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.unreported[id]++
+	if n := cs.unreported[id]; n >= maxUnreportedPolls {
+		delete(cs.unreported, id)
+		return fmt.Errorf("Job %s was reported neither by the scheduler's status nor by its accounting in %d polls", id, n)
+	}
+	return nil
+}
+
+// accountingDue checks whether the accounting of a job that is no longer in the scheduler's status should be
+// looked up on this poll. Since every lookup runs a separate command, e.g. qacct, it is backed off exponentially:
+// it runs on the 1st, 2nd, 4th, 8th... poll that did not find the job and on the last poll before the job is lost.
+func (cs *CLISession) accountingDue(id string) bool {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	poll := cs.unreported[id] + 1
+	return poll&(poll-1) == 0 || poll >= maxUnreportedPolls
+}
+
+func (cs *CLISession) reportedJob(id string) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	delete(cs.unreported, id)
+}
+
+// finishedJob looks up the job in the scheduler's accounting if the scheduler supports it
+func (cs *CLISession) finishedJob(id string) (JobInfo, bool) {
+	fcli, ok := cs.cli.(FinishedJobCLI)
+	if !ok {
+		return JobInfo{}, false
+	}
+	output, err := runSchedulerCmd(fcli.FinishedCmd(id))
+	if err != nil {
+		// the accounting is usually written some time after the job finished
+		return JobInfo{}, false
+	}
+	return fcli.ParseFinished(output)
+}
+
+// updateFromCLIStatus copies the job's state reported by the scheduler's command line tools
+func updateFromCLIStatus(j *JobInfo, status JobInfo) {
+	j.State = status.State
+	j.ExitStatus = status.ExitStatus
+	if len(status.AllocatedMachines) > 0 {
+		j.AllocatedMachines = status.AllocatedMachines
+	}
+	if status.QueueName != "" {
+		j.QueueName = status.QueueName
+	}
+	if status.Slots > 0 {
+		j.Slots = status.Slots
+	}
+	if status.WallclockTime > 0 {
+		j.WallclockTime = status.WallclockTime
+	}
+}
+
+// ControlJob DRMAASession method
+func (cs *CLISession) ControlJob(j *JobInfo, action process.JobAction) error {
+	cmd, err := cs.cli.ControlCmd(j.ID, action)
+	if err != nil {
+		return err
+	}
+	_, err = runSchedulerCmd(cmd)
+	return err
+}
+
+// Close DRMAASession method
+func (cs *CLISession) Close() error {
+	return nil
+}
+
+// runSchedulerCmd runs the scheduler command and returns its standard output.
+// If the command fails the returned error contains the command's standard error.
+func runSchedulerCmd(cmdline []string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(cmdline[0], cmdline[1:]...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return stdout.String(), fmt.Errorf("%s: %v %s", cmdline[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
@@ -0,0 +1,250 @@
+package drmaautils
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"config"
+	"process"
+)
+
+// fakeScheduler writes the scheduler commands as scripts to a directory that is put first on the PATH;
+// the submit command records its arguments in submit.args in that directory
+func fakeScheduler(t *testing.T, commands map[string]string) (string, func()) {
+	binDir, err := ioutil.TempDir("", "scheduler")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, script := range commands {
+		content := "#!/bin/sh\n" + script + "\n"
+		if err := ioutil.WriteFile(filepath.Join(binDir, name), []byte(content), 0775); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := os.Getenv("PATH")
+	os.Setenv("PATH", binDir+string(os.PathListSeparator)+path)
+	return binDir, func() {
+		os.Setenv("PATH", path)
+		os.RemoveAll(binDir)
+	}
+}
+
+func TestCLIProcessorsWithFakeSchedulers(t *testing.T) {
+	recordArgs := `echo "$@" > "$(dirname "$0")/submit.args"; `
+	testData := []struct {
+		processorType string
+		commands      map[string]string
+		submitOptions []string
+		failed        bool
+	}{
+		{
+			"qsub",
+			map[string]string{
+				"qsub":  recordArgs + "echo 7",
+				"qstat": "true",
+				"qacct": "printf 'qname short\\nhostname node1\\nfailed 0\\nexit_status 0\\n'",
+			},
+			[]string{"-q short", "-pe batch 4", "-b y -shell no sh -c exit 0"},
+			false,
+		},
+		{
+			"sbatch",
+			map[string]string{
+				"sbatch": recordArgs + "echo 42",
+				"sacct":  "echo '42|FAILED|2:0|node1|short|4|3'",
+			},
+			[]string{"-p short", "--cpus-per-task=4", "--wrap exec 'sh' '-c' 'exit 0'"},
+			true,
+		},
+		{
+			"bsub",
+			map[string]string{
+				"bsub":  recordArgs + "echo 'Job <9> is submitted to queue <short>.'",
+				"bjobs": "echo '9|DONE|-|short|4*node2|5 second(s)'",
+			},
+			[]string{"-q short", "-n 4", "'sh' '-c' 'exit 0'"},
+			false,
+		},
+	}
+	for _, td := range testData {
+		binDir, restorePath := fakeScheduler(t, td.commands)
+		outputDir, err := ioutil.TempDir("", td.processorType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, err := process.NewProcessor(td.processorType, process.ProcessorParams{
+			SessionName: "test",
+			Resources: config.Config{
+				"outputDir":              outputDir,
+				"workingDir":             outputDir,
+				"jobPollingInterval":     0.01,
+				"ugeQueue":               "short",
+				"ugeMinSlots":            4,
+				"ugeParallelEnvironment": "batch",
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = p.Run(context.Background(), process.Job{
+			Executable:     "sh",
+			Name:           "cli",
+			CmdlineBuilder: shCmdlineBuilder{script: "exit 0"},
+		})
+		var jobErr *JobFailedError
+		if td.failed != errors.As(err, &jobErr) {
+			t.Errorf("%s: expected failure %t but got %v", td.processorType, td.failed, err)
+		}
+		submitArgs, err := ioutil.ReadFile(filepath.Join(binDir, "submit.args"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, option := range td.submitOptions {
+			if !strings.Contains(string(submitArgs), option) {
+				t.Errorf("%s: expected %q in the submit options %s", td.processorType, option, submitArgs)
+			}
+		}
+		restorePath()
+		os.RemoveAll(outputDir)
+	}
+}
+
+func TestUGEParseStatus(t *testing.T) {
+	output := `<?xml version='1.0'?>
+<job_info  xmlns:xsd="http://arc.liv.ac.uk/repos/darcs/sge/source/dist/util/resources/schemas/qstat/qstat.xsd">
+  <queue_info>
+    <job_list state="running">
+      <JB_job_number>7</JB_job_number>
+      <JB_name>single</JB_name>
+      <state>r</state>
+      <queue_name>short@node1</queue_name>
+      <slots>4</slots>
+    </job_list>
+    <job_list state="running">
+      <JB_job_number>8</JB_job_number>
+      <JB_name>bulk</JB_name>
+      <state>r</state>
+      <queue_name>short@node2</queue_name>
+      <slots>1</slots>
+      <tasks>1</tasks>
+    </job_list>
+  </queue_info>
+  <job_info>
+    <job_list state="pending">
+      <JB_job_number>8</JB_job_number>
+      <JB_name>bulk</JB_name>
+      <state>qw</state>
+      <queue_name></queue_name>
+      <slots>1</slots>
+      <tasks>2-6:2</tasks>
+    </job_list>
+    <job_list state="pending">
+      <JB_job_number>9</JB_job_number>
+      <JB_name>held</JB_name>
+      <state>hqw</state>
+      <queue_name></queue_name>
+      <slots>1</slots>
+    </job_list>
+  </job_info>
+</job_info>`
+	statuses := UGECLI{}.ParseStatus(output)
+	expected := map[string]JobState{
+		"7":   Running,
+		"8.1": Running,
+		"8.2": Queued,
+		"8.4": Queued,
+		"8.6": Queued,
+		"9":   QueuedHeld,
+	}
+	if len(statuses) != len(expected) {
+		t.Errorf("Expected %d jobs but got %v", len(expected), statuses)
+	}
+	for id, state := range expected {
+		if statuses[id].State != state {
+			t.Errorf("Expected job %s to be %v but got %v", id, state, statuses[id].State)
+		}
+	}
+	if ji := statuses["7"]; ji.QueueName != "short" || len(ji.AllocatedMachines) != 1 || ji.AllocatedMachines[0] != "node1" || ji.Slots != 4 {
+		t.Errorf("Expected job 7 to run with 4 slots on short@node1 but got %+v", ji)
+	}
+	if cmd := (UGECLI{}).FinishedCmd("8.4"); strings.Join(cmd, " ") != "qacct -j 8 -t 4" {
+		t.Errorf("Expected the accounting of task 4 of job 8 but got %v", cmd)
+	}
+}
+
+func TestExecutionHostsFromTheAccounting(t *testing.T) {
+	_, restorePath := fakeScheduler(t, map[string]string{
+		"qacct": `if [ "$2" = 7 ]; then printf 'qname short\nhostname node1\nfailed 0\nexit_status 0\n'; else exit 1; fi`,
+	})
+	defer restorePath()
+	if hosts := executionHosts("7"); len(hosts) != 1 || hosts[0] != "node1" {
+		t.Error("Expected job 7 to run on node1 but got", hosts)
+	}
+	if hosts := executionHosts("8"); len(hosts) != 0 {
+		t.Error("Expected no hosts for a job without accounting but got", hosts)
+	}
+}
+
+func TestCLIProcessorFailsJobsTheSchedulerDoesNotReport(t *testing.T) {
+	defer func(polls int) { maxUnreportedPolls = polls }(maxUnreportedPolls)
+	maxUnreportedPolls = 10
+
+	binDir, restorePath := fakeScheduler(t, map[string]string{
+		"qsub":  "echo 7",
+		"qstat": "true",
+		"qacct": `echo "$@" >> "$(dirname "$0")/qacct.calls"; echo 'error: job id 7 not found' >&2; exit 1`,
+	})
+	defer restorePath()
+	outputDir, err := ioutil.TempDir("", "qsub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(outputDir)
+	p, err := process.NewProcessor("qsub", process.ProcessorParams{
+		SessionName: "test",
+		Resources: config.Config{
+			"outputDir":          outputDir,
+			"workingDir":         outputDir,
+			"jobPollingInterval": 0.01,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- p.Run(context.Background(), process.Job{Name: "lost", CmdlineBuilder: shCmdlineBuilder{script: "exit 0"}})
+	}()
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), "reported neither") {
+			t.Errorf("Expected the unreported job to fail but got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("Expected the unreported job to fail but it is still waited for")
+	}
+	// the accounting is looked up on the 1st, 2nd, 4th, 8th and the last poll
+	calls, err := ioutil.ReadFile(filepath.Join(binDir, "qacct.calls"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(calls), "\n"); n != 5 {
+		t.Errorf("Expected 5 accounting lookups but got %d", n)
+	}
+}
+
+func TestLSFSubmitCmdRequestsMemoryInMB(t *testing.T) {
+	var jt JobTemplate
+	jt.RemoteCommand = "sh"
+	jt.MinPhysMemory = 4 * 1024 * 1024
+	cmd := strings.Join(LSFCLI{}.SubmitCmd(jt), " ")
+	if !strings.Contains(cmd, "-R rusage[mem=4096MB]") {
+		t.Errorf("Expected a 4096MB memory reservation in %s", cmd)
+	}
+}
@@ -0,0 +1,491 @@
+package drmaautils
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"os"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"process"
+)
+
+// runtimeLimit returns the job's runtime limit set as the "h_rt" resource limit in seconds
+func runtimeLimit(jt JobTemplate) time.Duration {
+	seconds, err := strconv.ParseInt(jt.ResourceLimits["h_rt"], 10, 64)
+	if err != nil {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// logPath returns the path of the job's output or error file named <jobName>.<suffix><jobID>
+// so that GridJobInfo finds it the same way as the output written by UGE
+func logPath(dir string, jt JobTemplate, suffix, jobIDPattern string) string {
+	if dir == "" {
+		dir = jt.WorkingDirectory
+	}
+	return filepath.Join(dir, jt.JobName+"."+suffix+jobIDPattern)
+}
+
+// environmentList returns the job's environment as a sorted list of name=value
+func environmentList(jt JobTemplate) []string {
+	env := make([]string, 0, len(jt.JobEnvironment))
+	for k, v := range jt.JobEnvironment {
+		env = append(env, k+"="+v)
+	}
+	sort.Strings(env)
+	return env
+}
+
+// shellCommand returns the job's command quoted for the shell
+func shellCommand(jt JobTemplate) string {
+	cmd := make([]string, 0, len(jt.Args)+1)
+	cmd = append(cmd, process.ShellQuote(jt.RemoteCommand))
+	for _, a := range jt.Args {
+		cmd = append(cmd, process.ShellQuote(a))
+	}
+	return strings.Join(cmd, " ")
+}
+
+// UGECLI translates job templates into qsub options. The jobs are monitored with qstat and
+// once they leave the queue their final state is read with qacct. The tasks of array jobs
+// are identified as <jobID>.<taskID>.
+type UGECLI struct{}
+
+// SubmitCmd SchedulerCLI method
+func (UGECLI) SubmitCmd(jt JobTemplate) []string {
+	cmd := []string{"qsub", "-terse", "-N", jt.JobName, "-wd", jt.WorkingDirectory}
+	if jt.OutputPath != "" {
+		cmd = append(cmd, "-o", jt.OutputPath)
+	}
+	if jt.ErrorPath != "" {
+		cmd = append(cmd, "-e", jt.ErrorPath)
+	}
+	if jt.InputPath != "" {
+		cmd = append(cmd, "-i", jt.InputPath)
+	}
+	if jt.SubmitAsHold {
+		cmd = append(cmd, "-h")
+	}
+	if len(jt.JobEnvironment) > 0 {
+		cmd = append(cmd, "-v", strings.Join(environmentList(jt), ","))
+	}
+	var nativeSpecBuffer bytes.Buffer
+	appendAccountID(&nativeSpecBuffer, jt)
+	appendPE(&nativeSpecBuffer, jt)
+	appendQueue(&nativeSpecBuffer, jt)
+	appendResourceLimits(&nativeSpecBuffer, jt)
+	appendNativeSpec(&nativeSpecBuffer, jt)
+	cmd = append(cmd, strings.Fields(nativeSpecBuffer.String())...)
+	cmd = append(cmd, "-b", "y", "-shell", "no", jt.RemoteCommand)
+	return append(cmd, jt.Args...)
+}
+
+// ParseJobID SchedulerCLI method
+func (UGECLI) ParseJobID(output string) (string, error) {
+	jobID := strings.TrimSpace(output)
+	if jobID == "" {
+		return "", fmt.Errorf("qsub did not return a job ID")
+	}
+	return jobID, nil
+}
+
+// StatusCmd SchedulerCLI method. qstat reports all the unfinished jobs of the user.
+func (UGECLI) StatusCmd(ids []string) []string {
+	return []string{"qstat", "-u", currentUser(), "-xml"}
+}
+
+// currentUser returns the name of the user that runs the driver or "*", i.e., all users, if it is not known
+func currentUser() string {
+	if name := os.Getenv("USER"); name != "" {
+		return name
+	}
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return "*"
+}
+
+// ugeJobList a job or a range of tasks of an array job reported by qstat -xml
+type ugeJobList struct {
+	JobNumber string `xml:"JB_job_number"`
+	State     string `xml:"state"`
+	QueueName string `xml:"queue_name"`
+	Slots     int64  `xml:"slots"`
+	Tasks     string `xml:"tasks"`
+}
+
+// ugeQstat the output of qstat -xml; the running jobs are listed under queue_info and the pending ones under job_info
+type ugeQstat struct {
+	RunningJobs []ugeJobList `xml:"queue_info>job_list"`
+	PendingJobs []ugeJobList `xml:"job_info>job_list"`
+}
+
+// ParseStatus SchedulerCLI method
+func (UGECLI) ParseStatus(output string) map[string]JobInfo {
+	statuses := make(map[string]JobInfo)
+	var qstat ugeQstat
+	if err := xml.Unmarshal([]byte(output), &qstat); err != nil {
+		if strings.TrimSpace(output) != "" {
+			log.Printf("Error parsing the qstat output: %v", err)
+		}
+		return statuses
+	}
+	for _, jl := range append(qstat.RunningJobs, qstat.PendingJobs...) {
+		ji := JobInfo{State: convertUGEState(jl.State), Slots: jl.Slots}
+		if queueInstance := strings.SplitN(jl.QueueName, "@", 2); len(queueInstance) == 2 {
+			ji.QueueName = queueInstance[0]
+			ji.AllocatedMachines = []string{queueInstance[1]}
+		}
+		if jl.Tasks == "" {
+			ji.ID = jl.JobNumber
+			statuses[ji.ID] = ji
+			continue
+		}
+		for _, task := range expandUGETasks(jl.Tasks) {
+			ji.ID = jl.JobNumber + "." + task
+			statuses[ji.ID] = ji
+		}
+	}
+	return statuses
+}
+
+// expandUGETasks returns the IDs of the tasks reported by qstat either as a single task or as
+// a comma separated list of tasks and task ranges, e.g., "1,4-10:2"
+func expandUGETasks(tasks string) []string {
+	var ids []string
+	for _, r := range strings.Split(tasks, ",") {
+		var first, last, step int64
+		if n, _ := fmt.Sscanf(r, "%d-%d:%d", &first, &last, &step); n < 2 {
+			if _, err := strconv.ParseInt(r, 10, 64); err == nil {
+				ids = append(ids, r)
+			}
+			continue
+		}
+		if step <= 0 {
+			step = 1
+		}
+		for t := first; t <= last; t += step {
+			ids = append(ids, strconv.FormatInt(t, 10))
+		}
+	}
+	return ids
+}
+
+// convertUGEState converts the state reported by qstat, e.g., "qw", "hqw", "r" or "Eqw", to JobState
+func convertUGEState(state string) JobState {
+	switch {
+	case strings.Contains(state, "E"):
+		return Failed
+	case strings.Contains(state, "h"):
+		return QueuedHeld
+	case strings.ContainsAny(state, "sST"):
+		return Suspended
+	case strings.ContainsAny(state, "rtd"):
+		return Running
+	case strings.Contains(state, "R"):
+		return Requeued
+	case strings.Contains(state, "q"):
+		return Queued
+	}
+	return Undetermined
+}
+
+// FinishedCmd FinishedJobCLI method
+func (UGECLI) FinishedCmd(id string) []string {
+	if jobTask := strings.SplitN(id, ".", 2); len(jobTask) == 2 {
+		return []string{"qacct", "-j", jobTask[0], "-t", jobTask[1]}
+	}
+	return []string{"qacct", "-j", id}
+}
+
+// ParseFinished FinishedJobCLI method
+func (UGECLI) ParseFinished(output string) (JobInfo, bool) {
+	var ji JobInfo
+	found := false
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		switch fields[0] {
+		case "qname":
+			ji.QueueName = fields[1]
+		case "hostname":
+			ji.AllocatedMachines = []string{fields[1]}
+		case "slots":
+			ji.Slots, _ = strconv.ParseInt(fields[1], 10, 64)
+		case "ru_wallclock":
+			if v, err := strconv.ParseFloat(strings.TrimSuffix(fields[1], "s"), 64); err == nil {
+				ji.WallclockTime = time.Duration(v * float64(time.Second))
+			}
+		case "failed":
+			found = true
+			if fields[1] != "0" {
+				ji.State = Failed
+			}
+		case "exit_status":
+			ji.ExitStatus, _ = strconv.Atoi(fields[1])
+		}
+	}
+	if !found {
+		return ji, false
+	}
+	if ji.State == Failed || ji.ExitStatus != 0 {
+		ji.State = Failed
+	} else {
+		ji.State = Done
+	}
+	return ji, true
+}
+
+// ControlCmd SchedulerCLI method
+func (UGECLI) ControlCmd(id string, action process.JobAction) ([]string, error) {
+	switch action {
+	case process.Terminate:
+		return []string{"qdel", id}, nil
+	case process.Suspend:
+		return []string{"qmod", "-sj", id}, nil
+	case process.Resume:
+		return []string{"qmod", "-usj", id}, nil
+	case process.Hold:
+		return []string{"qhold", id}, nil
+	case process.Release:
+		return []string{"qrls", id}, nil
+	}
+	return nil, &process.UnsupportedActionError{Name: id, Action: action}
+}
+
+// SlurmCLI translates job templates into sbatch options. The jobs are monitored with sacct
+// which also reports the jobs that already finished.
+type SlurmCLI struct{}
+
+// SubmitCmd SchedulerCLI method
+func (SlurmCLI) SubmitCmd(jt JobTemplate) []string {
+	cmd := []string{"sbatch", "--parsable", "-J", jt.JobName, "-D", jt.WorkingDirectory,
+		"-o", logPath(jt.OutputPath, jt, "o", "%j"),
+		"-e", logPath(jt.ErrorPath, jt, "e", "%j"),
+	}
+	if jt.InputPath != "" {
+		cmd = append(cmd, "-i", jt.InputPath)
+	}
+	if jt.SubmitAsHold {
+		cmd = append(cmd, "-H")
+	}
+	if jt.AccountingID != "" {
+		cmd = append(cmd, "-A", jt.AccountingID)
+	}
+	if jt.QueueName != "" {
+		cmd = append(cmd, "-p", jt.QueueName)
+	}
+	if jt.MinSlots > 0 {
+		cmd = append(cmd, fmt.Sprintf("--cpus-per-task=%d", jt.MinSlots))
+	}
+	if jt.MinPhysMemory > 0 {
+		cmd = append(cmd, fmt.Sprintf("--mem=%dM", jt.MinPhysMemory/1024))
+	}
+	if limit := runtimeLimit(jt); limit > 0 {
+		seconds := int64(limit / time.Second)
+		cmd = append(cmd, fmt.Sprintf("--time=%d:%02d:%02d", seconds/3600, seconds/60%60, seconds%60))
+	}
+	if len(jt.JobEnvironment) > 0 {
+		cmd = append(cmd, "--export=ALL,"+strings.Join(environmentList(jt), ","))
+	}
+	cmd = append(cmd, strings.Fields(jt.GetExtension("uge_jt_native"))...)
+	return append(cmd, "--wrap", "exec "+shellCommand(jt))
+}
+
+// ParseJobID SchedulerCLI method. The parsable output is <jobID>[;<cluster>].
+func (SlurmCLI) ParseJobID(output string) (string, error) {
+	jobID := strings.SplitN(strings.TrimSpace(output), ";", 2)[0]
+	if jobID == "" {
+		return "", fmt.Errorf("sbatch did not return a job ID")
+	}
+	return jobID, nil
+}
+
+// StatusCmd SchedulerCLI method
+func (SlurmCLI) StatusCmd(ids []string) []string {
+	return []string{"sacct", "-n", "-P", "-X", "-o", "JobID,State,ExitCode,NodeList,Partition,AllocCPUS,ElapsedRaw",
+		"-j", strings.Join(ids, ",")}
+}
+
+// ParseStatus SchedulerCLI method
+func (SlurmCLI) ParseStatus(output string) map[string]JobInfo {
+	statuses := make(map[string]JobInfo)
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.Split(strings.TrimSpace(scanner.Text()), "|")
+		if len(fields) < 7 {
+			continue
+		}
+		ji := JobInfo{ID: fields[0], State: convertSlurmState(fields[1]), QueueName: fields[4]}
+		ji.ExitStatus, _ = strconv.Atoi(strings.SplitN(fields[2], ":", 2)[0])
+		if fields[3] != "" && fields[3] != "None assigned" {
+			ji.AllocatedMachines = []string{fields[3]}
+		}
+		ji.Slots, _ = strconv.ParseInt(fields[5], 10, 64)
+		if elapsed, err := strconv.ParseInt(fields[6], 10, 64); err == nil {
+			ji.WallclockTime = time.Duration(elapsed) * time.Second
+		}
+		statuses[ji.ID] = ji
+	}
+	return statuses
+}
+
+// convertSlurmState converts the state reported by sacct, e.g., "PENDING" or "CANCELLED by 1234", to JobState
+func convertSlurmState(state string) JobState {
+	switch strings.Fields(state + " ")[0] {
+	case "PENDING":
+		return Queued
+	case "RUNNING", "COMPLETING":
+		return Running
+	case "SUSPENDED":
+		return Suspended
+	case "REQUEUED":
+		return Requeued
+	case "REQUEUE_HOLD":
+		return RequeuedHeld
+	case "COMPLETED":
+		return Done
+	case "FAILED", "CANCELLED", "TIMEOUT", "NODE_FAIL", "OUT_OF_MEMORY", "PREEMPTED", "BOOT_FAIL", "DEADLINE":
+		return Failed
+	}
+	return Undetermined
+}
+
+// ControlCmd SchedulerCLI method
+func (SlurmCLI) ControlCmd(id string, action process.JobAction) ([]string, error) {
+	switch action {
+	case process.Terminate:
+		return []string{"scancel", id}, nil
+	case process.Suspend:
+		return []string{"scontrol", "suspend", id}, nil
+	case process.Resume:
+		return []string{"scontrol", "resume", id}, nil
+	case process.Hold:
+		return []string{"scontrol", "hold", id}, nil
+	case process.Release:
+		return []string{"scontrol", "release", id}, nil
+	}
+	return nil, &process.UnsupportedActionError{Name: id, Action: action}
+}
+
+// LSFCLI translates job templates into bsub options. The jobs are monitored with bjobs
+// which also reports the jobs that finished recently.
+type LSFCLI struct{}
+
+// SubmitCmd SchedulerCLI method
+func (LSFCLI) SubmitCmd(jt JobTemplate) []string {
+	cmd := []string{"bsub", "-J", jt.JobName, "-cwd", jt.WorkingDirectory,
+		"-o", logPath(jt.OutputPath, jt, "o", "%J"),
+		"-e", logPath(jt.ErrorPath, jt, "e", "%J"),
+	}
+	if jt.InputPath != "" {
+		cmd = append(cmd, "-i", jt.InputPath)
+	}
+	if jt.SubmitAsHold {
+		cmd = append(cmd, "-H")
+	}
+	if jt.AccountingID != "" {
+		cmd = append(cmd, "-P", jt.AccountingID)
+	}
+	if jt.QueueName != "" {
+		cmd = append(cmd, "-q", jt.QueueName)
+	}
+	if jt.MinSlots > 0 {
+		cmd = append(cmd, "-n", strconv.FormatInt(jt.MinSlots, 10), "-R", "span[hosts=1]")
+	}
+	if jt.MinPhysMemory > 0 {
+		cmd = append(cmd, "-R", fmt.Sprintf("rusage[mem=%dMB]", jt.MinPhysMemory/1024))
+	}
+	if limit := runtimeLimit(jt); limit > 0 {
+		// the runtime limit is in minutes
+		cmd = append(cmd, "-W", strconv.FormatInt(int64((limit+time.Minute-1)/time.Minute), 10))
+	}
+	if len(jt.JobEnvironment) > 0 {
+		cmd = append(cmd, "-env", "all,"+strings.Join(environmentList(jt), ","))
+	}
+	cmd = append(cmd, strings.Fields(jt.GetExtension("uge_jt_native"))...)
+	return append(cmd, shellCommand(jt))
+}
+
+var lsfJobIDRegexp = regexp.MustCompile(`Job <(\d+)> is submitted`)
+
+// ParseJobID SchedulerCLI method. bsub reports "Job <jobID> is submitted to queue <queue>."
+func (LSFCLI) ParseJobID(output string) (string, error) {
+	match := lsfJobIDRegexp.FindStringSubmatch(output)
+	if match == nil {
+		return "", fmt.Errorf("bsub did not return a job ID: %s", strings.TrimSpace(output))
+	}
+	return match[1], nil
+}
+
+// StatusCmd SchedulerCLI method
+func (LSFCLI) StatusCmd(ids []string) []string {
+	return append([]string{"bjobs", "-a", "-noheader", "-o", "jobid stat exit_code queue exec_host run_time delimiter='|'"}, ids...)
+}
+
+// ParseStatus SchedulerCLI method
+func (LSFCLI) ParseStatus(output string) map[string]JobInfo {
+	statuses := make(map[string]JobInfo)
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.Split(strings.TrimSpace(scanner.Text()), "|")
+		if len(fields) < 6 {
+			continue
+		}
+		ji := JobInfo{ID: fields[0], State: convertLSFState(fields[1]), QueueName: fields[3]}
+		ji.ExitStatus, _ = strconv.Atoi(fields[2])
+		if fields[4] != "" && fields[4] != "-" {
+			ji.AllocatedMachines = strings.Split(fields[4], ":")
+		}
+		if runTime, err := strconv.ParseInt(strings.Fields(fields[5] + " ")[0], 10, 64); err == nil {
+			ji.WallclockTime = time.Duration(runTime) * time.Second
+		}
+		statuses[ji.ID] = ji
+	}
+	return statuses
+}
+
+// convertLSFState converts the state reported by bjobs, e.g., "PEND" or "RUN", to JobState
+func convertLSFState(state string) JobState {
+	switch state {
+	case "PEND", "WAIT":
+		return Queued
+	case "PSUSP":
+		return QueuedHeld
+	case "RUN":
+		return Running
+	case "USUSP", "SSUSP":
+		return Suspended
+	case "DONE":
+		return Done
+	case "EXIT", "ZOMBI":
+		return Failed
+	}
+	return Undetermined
+}
+
+// ControlCmd SchedulerCLI method. LSF holds a pending job by suspending it.
+func (LSFCLI) ControlCmd(id string, action process.JobAction) ([]string, error) {
+	switch action {
+	case process.Terminate:
+		return []string{"bkill", id}, nil
+	case process.Suspend, process.Hold:
+		return []string{"bstop", id}, nil
+	case process.Resume, process.Release:
+		return []string{"bresume", id}, nil
+	}
+	return nil, &process.UnsupportedActionError{Name: id, Action: action}
+}
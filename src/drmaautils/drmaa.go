@@ -2,6 +2,8 @@ package drmaautils
 
 import (
 	"time"
+
+	"process"
 )
 
 // DRMAAProxy - drmaa proxy
@@ -13,9 +15,28 @@ type DRMAAProxy interface {
 type DRMAASession interface {
 	RunJob(jt JobTemplate) (*JobInfo, error)
 	UpdateJobInfo(j *JobInfo) error
+	ControlJob(j *JobInfo, action process.JobAction) error
 	Close() error
 }
 
+// DRMAABatchSession - drmaa session that can update the info of many jobs with a single request
+type DRMAABatchSession interface {
+	// UpdateJobsInfo updates the given jobs and returns an error for each job that could not be updated
+	UpdateJobsInfo(jobs []*JobInfo) []error
+}
+
+// DRMAABulkSession - drmaa session that can submit array jobs
+type DRMAABulkSession interface {
+	// RunBulkJobs submits an array job with the tasks begin, begin+step, ..., end and returns the info of every task
+	RunBulkJobs(jt JobTemplate, begin, end, step int) ([]*JobInfo, error)
+}
+
+// DRMAASessionDestroyer - drmaa proxy whose sessions persist after they are closed until they are destroyed
+type DRMAASessionDestroyer interface {
+	// DestroySession destroys the closed session with the given name
+	DestroySession(name string) error
+}
+
 // JobInfo - job info type
 type JobInfo struct {
 	Extension         `xml:"-" json:"-"`
@@ -144,3 +165,11 @@ func (jt *JobTemplate) GetExtension(extension string) string {
 type Extension struct {
 	ExtensionList map[string]string // stores the extension requests as string
 }
+
+func copyMap(in map[string]string) map[string]string {
+	out := make(map[string]string, len(in))
+	for k, v := range in {
+		out[k] = v
+	}
+	return out
+}
@@ -1,26 +1,81 @@
 package drmaautils
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"config"
 	"process"
 )
 
-const defaultJobTimeout = 10800
+const (
+	defaultJobTimeout = 10800
+	// defaultPollingIntervalInSec applies to the sessions that query the grid once for every job, e.g., the simulated session
+	defaultPollingIntervalInSec = 30
+	// defaultBatchPollingIntervalInSec applies to the sessions that query all the jobs with a single request
+	defaultBatchPollingIntervalInSec = 10
+)
+
+// JobFailedError is returned when the grid reports that a job failed. Grid failures, e.g.,
+// a node that went down, are often transient so the job may succeed if it is resubmitted.
+type JobFailedError struct {
+	ID string
+}
+
+// Error returns the failed job's ID
+func (e *JobFailedError) Error() string {
+	return fmt.Sprintf("Job %s failed", e.ID)
+}
+
+// Retriable always returns true
+func (e *JobFailedError) Retriable() bool {
+	return true
+}
+
+// SchedulerError is returned when the grid scheduler could not be reached or it rejected a request,
+// e.g., because qmaster is overloaded; such errors are usually transient.
+type SchedulerError struct {
+	Op  string
+	Err error
+}
+
+// Error returns the failed operation and the scheduler's error
+func (e *SchedulerError) Error() string {
+	return fmt.Sprintf("Error %s: %v", e.Op, e.Err)
+}
+
+// Unwrap returns the scheduler's error
+func (e *SchedulerError) Unwrap() error {
+	return e.Err
+}
+
+// Retriable always returns true
+func (e *SchedulerError) Retriable() bool {
+	return true
+}
 
 // GridJobInfo grid job info
 type GridJobInfo struct {
-	js              DRMAASession
-	jt              *JobTemplate
-	jobInfo         *JobInfo
-	jobTimeoutInSec int64
+	js         DRMAASession
+	jt         *JobTemplate
+	jobInfo    *JobInfo
+	limits     jobLimits
+	poller     *sessionPoller
+	tracker    *process.JobTracker
+	accounting *accountingReport
+	active     *activeJobs
+	state      *sessionJobState
+	// stagingScript the script that stages the job's files, if the processor generated one
+	stagingScript string
 }
 
 // JobStdout get the job standard output
@@ -65,51 +120,165 @@ func (gji GridJobInfo) openJobOutputFile(outputPattern string) (io.ReadCloser, e
 }
 
 // WaitForTermination wait for job's completion
-func (gji GridJobInfo) WaitForTermination() (err error) {
-	_, err = waitForState(gji.jobInfo, gji.js, Unset, gji.jobTimeoutInSec)
+func (gji GridJobInfo) WaitForTermination(ctx context.Context) (err error) {
+	_, err = waitForState(ctx, gji.jobInfo, gji.js, gji.poller, gji.tracker, Unset, gji.limits)
+	gji.active.remove(gji.jobInfo.ID)
+	gji.state.finished(gji.jt.JobName, gji.jobInfo.ID)
+	gji.tracker.Done(err)
+	gji.accounting.record(gji.jt.JobName, gji.jt.AccountingID, gji.jobInfo)
+	removeStagingScript(gji.stagingScript, err)
 	return err
 }
 
+// Control applies the action to the grid job
+func (gji GridJobInfo) Control(action process.JobAction) error {
+	return gji.js.ControlJob(&JobInfo{ID: gji.jobInfo.ID}, action)
+}
+
+// activeJobs the IDs of the jobs submitted by a processor that have not completed yet
+type activeJobs struct {
+	mu  sync.Mutex
+	ids map[string]struct{}
+}
+
+func (aj *activeJobs) add(id string) {
+	aj.mu.Lock()
+	defer aj.mu.Unlock()
+	aj.ids[id] = struct{}{}
+}
+
+func (aj *activeJobs) remove(id string) {
+	aj.mu.Lock()
+	defer aj.mu.Unlock()
+	delete(aj.ids, id)
+}
+
+func (aj *activeJobs) list() []string {
+	aj.mu.Lock()
+	defer aj.mu.Unlock()
+	ids := make([]string, 0, len(aj.ids))
+	for id := range aj.ids {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
 // GridProcessor processor that submits the job to the grid
 type GridProcessor struct {
 	process.JobWatcher
-	sessionName  string
-	accountingID string
-	resources    config.Config
-	dp           DRMAAProxy
-	js           DRMAASession
+	processorType string
+	sessionName   string
+	accountingID  string
+	resources     config.Config
+	dp            DRMAAProxy
+	js            DRMAASession
+	poller        *sessionPoller
+	accounting    *accountingReport
+	active        *activeJobs
+	state         *sessionJobState
 }
 
-// NewGridProcessor creates a grid processor
+// NewGridProcessor creates a grid processor. The state of all the jobs submitted by the processor
+// is polled together every "jobPollingInterval" seconds, which defaults to 10s for the sessions that query
+// all the jobs with a single request and to 30s for the sessions that query every job separately. If "submitAsHold" is set the jobs are submitted
+// in the held state and they only run after they are released, e.g., with ControlJobs. If "jobStateDir" is set
+// the IDs of the submitted jobs are saved there so that a processor created for the same session after
+// a restart reattaches to the jobs that are still queued or running instead of resubmitting them.
+// If "scratchDir" is set the jobs that declare staged files read and write them in a directory
+// of "scratchDir" on the execution node. DRMAA2 sessions hand the staged files to the DRMAA2 implementation.
+// For the DRMAA1, CLI and simulated sessions a generated staging script copies the files on the execution node,
+// which must therefore mount the shared file system; "scratchDir" only keeps the job's reads and writes off it.
+// The staging script is removed once the job completes.
 func NewGridProcessor(sessionName, accountingID string, drmaaProxy DRMAAProxy, resources config.Config) (p *GridProcessor, err error) {
 	p = &GridProcessor{
-		sessionName:  sessionName,
-		accountingID: accountingID,
-		resources:    resources,
-		dp:           drmaaProxy,
+		processorType: gridProcessorType(drmaaProxy),
+		sessionName:   sessionName,
+		accountingID:  accountingID,
+		resources:     resources,
+		dp:            drmaaProxy,
+		accounting:    sessionAccounting(sessionName),
+		active:        &activeJobs{ids: make(map[string]struct{})},
 	}
 	if p.js, err = p.dp.CreateSession(sessionName); err != nil {
 		return p, fmt.Errorf("Cannot create job session '%s' for %s: %v", sessionName, accountingID, err)
 	}
+	acquireSession(sessionName)
+	if jobStateDir := resources.GetStringProperty("jobStateDir"); jobStateDir != "" {
+		if p.state, err = openSessionJobState(jobStateDir, sessionName); err != nil {
+			return p, err
+		}
+	}
+	p.poller = newSessionPoller(p.js, sessionPollingInterval(p.js, resources))
 	return p, nil
 }
 
+// sessionPollingInterval returns the interval between two polls of the session's jobs
+func sessionPollingInterval(js DRMAASession, resources config.Config) time.Duration {
+	pollingInterval := resources.GetFloat64Property("jobPollingInterval")
+	if pollingInterval <= 0 {
+		if _, ok := js.(DRMAABatchSession); ok {
+			pollingInterval = defaultBatchPollingIntervalInSec
+		} else {
+			pollingInterval = defaultPollingIntervalInSec
+		}
+	}
+	return time.Duration(pollingInterval * float64(time.Second))
+}
+
+// typedProxy is implemented by the proxies that know the processor type they were registered as
+type typedProxy interface {
+	processorType() string
+}
+
+var (
+	sessionUsersMu sync.Mutex
+	// sessionUsers the number of open processors of every session indexed by the session name
+	sessionUsers = make(map[string]int)
+)
+
+func acquireSession(sessionName string) {
+	sessionUsersMu.Lock()
+	defer sessionUsersMu.Unlock()
+	sessionUsers[sessionName]++
+}
+
+// releaseSession reports whether the session is no longer used by any processor
+func releaseSession(sessionName string) bool {
+	sessionUsersMu.Lock()
+	defer sessionUsersMu.Unlock()
+	sessionUsers[sessionName]--
+	if sessionUsers[sessionName] > 0 {
+		return false
+	}
+	delete(sessionUsers, sessionName)
+	return true
+}
+
+// gridProcessorType returns the processor type that corresponds to the DRMAA proxy
+func gridProcessorType(drmaaProxy DRMAAProxy) string {
+	if tp, ok := drmaaProxy.(typedProxy); ok {
+		return tp.processorType()
+	}
+	return "drmaa"
+}
+
 // Run the given job
-func (p *GridProcessor) Run(j process.Job) error {
-	ji, err := p.Start(j)
+func (p *GridProcessor) Run(ctx context.Context, j process.Job) error {
+	ji, err := p.Start(ctx, j)
 	if err != nil {
-		return fmt.Errorf("Error starting %v: %v", j, err)
+		return fmt.Errorf("Error starting %v: %w", j, err)
 	}
-	return p.Wait(ji)
+	return p.Wait(ctx, ji)
 }
 
-// Start submits a single job to the grid
-func (p *GridProcessor) Start(j process.Job) (process.Info, error) {
+// Start submits a single job to the grid and waits until the job runs. Jobs submitted as held
+// are not waited for since they only run after they are released.
+func (p *GridProcessor) Start(ctx context.Context, j process.Job) (process.Info, error) {
 	var (
-		jt       JobTemplate
-		jobInfo  *JobInfo
-		err      error
-		jTimeout int64 // job timeout
+		jt      JobTemplate
+		jobInfo *JobInfo
+		err     error
 	)
 
 	defer func() {
@@ -117,10 +286,55 @@ func (p *GridProcessor) Start(j process.Job) (process.Info, error) {
 			err = fmt.Errorf("Panic while processing job %s, %v: %r", jt.RemoteCommand, jt.Args, r)
 		}
 	}()
+	if jt, err = p.jobTemplate(j); err != nil {
+		return nil, err
+	}
+	limits := p.jobLimits()
+	fingerprint := templateFingerprint(jt)
+
+	tracker := process.NewJobTracker(ctx, p.processorType, j.Name)
+	var stagingScript string
+	if reattached, found := p.reattach(jt, fingerprint); found {
+		jobInfo = reattached
+	} else {
+		if stagingScript, err = p.stageThroughScript(&jt); err != nil {
+			tracker.Done(err)
+			return nil, err
+		}
+		// Submit the Job
+		log.Printf("Submit (%d-%d) %s %s %v\n ", jt.MinSlots, jt.MaxSlots, j.Name, j.Executable, jt.Args)
+		if jobInfo, err = p.js.RunJob(jt); err != nil {
+			removeStagingScript(stagingScript, nil)
+			err = &SchedulerError{Op: "submitting " + j.Name, Err: err}
+			tracker.Done(err)
+			return nil, err
+		}
+		log.Printf("Submitted job %s\n", jobInfo.ID)
+	}
+	gji := p.submitted(jt, jobInfo, tracker, fingerprint, stagingScript)
+	if jt.SubmitAsHold {
+		// a held job only runs after it is released so leave the waiting to WaitForTermination
+		return gji, nil
+	}
+	if _, err = waitForState(ctx, jobInfo, p.js, p.poller, tracker, Running, limits); err != nil {
+		p.active.remove(jobInfo.ID)
+		p.state.finished(jt.JobName, jobInfo.ID)
+		tracker.Done(err)
+		p.accounting.record(j.Name, p.accountingID, jobInfo)
+		removeStagingScript(stagingScript, err)
+	}
+	return gji, err
+}
+
+// jobTemplate creates the template for submitting the job to the grid
+func (p *GridProcessor) jobTemplate(j process.Job) (jt JobTemplate, err error) {
+	if j, err = p.stagedJob(&jt, j); err != nil {
+		return jt, err
+	}
 	jt.RemoteCommand = j.Executable
-	cmdline, err := j.CmdlineBuilder.GetCmdlineArgs(j.JArgs)
+	cmdline, err := j.CmdlineArgs()
 	if err != nil {
-		return nil, err
+		return jt, err
 	}
 	jt.Args = make([]string, len(cmdline), len(cmdline))
 	copy(jt.Args, cmdline)
@@ -140,44 +354,217 @@ func (p *GridProcessor) Start(j process.Job) (process.Info, error) {
 	jt.JobEnvironment = p.resources.GetStringMapProperty("ugeJobEnvironment")
 	jt.OutputPath = p.resources.GetStringProperty("outputDir")
 	jt.ErrorPath = p.resources.GetStringProperty("errorDir")
+	jt.SubmitAsHold = p.resources.GetBoolProperty("submitAsHold")
+	jt.SetExtension("uge_jt_pe", p.resources.GetStringProperty("ugeParallelEnvironment"))
+	p.setJobRequirements(&jt, process.JobRequirements(j, p.resources))
+	return jt, nil
+}
+
+// setJobRequirements overrides the global grid settings with the requirements of the job or of its role's profile.
+// The memory is requested as the "ugeMemoryResource" resource, e.g., "mem_free", if it is set
+// and the runtime limit as the "h_rt" resource.
+func (p *GridProcessor) setJobRequirements(jt *JobTemplate, r process.ResourceRequirements) {
+	if r.Queue != "" {
+		jt.QueueName = r.Queue
+	}
+	if r.Slots > 0 {
+		jt.MinSlots = int64(r.Slots)
+		jt.MaxSlots = int64(r.Slots)
+	}
+	if r.MemoryMB > 0 {
+		jt.MinPhysMemory = int64(r.MemoryMB) * 1024 // KB
+		if memoryResource := p.resources.GetStringProperty("ugeMemoryResource"); memoryResource != "" {
+			jt.ResourceLimits[memoryResource] = fmt.Sprintf("%dM", r.MemoryMB)
+		}
+	}
+	if r.RuntimeLimit > 0 {
+		jt.ResourceLimits["h_rt"] = strconv.FormatInt(int64(r.RuntimeLimit/time.Second), 10)
+	}
+	if r.NativeSpec != "" {
+		jt.SetExtension("uge_jt_native", r.NativeSpec)
+	}
+}
 
-	if jTimeout = p.resources.GetInt64Property("jobTimeout"); jTimeout == 0 {
-		jTimeout = defaultJobTimeout
+// jobLimits returns the maximum time a job may wait in the queue, "queueTimeout", and the maximum time
+// it may run, "runTimeout", in seconds. Both default to "jobTimeout" which defaults to 3 hours;
+// a negative timeout means no limit.
+func (p *GridProcessor) jobLimits() jobLimits {
+	jobTimeout := p.resources.GetFloat64Property("jobTimeout")
+	if jobTimeout == 0 {
+		jobTimeout = defaultJobTimeout
+	}
+	limit := func(name string) time.Duration {
+		timeout := p.resources.GetFloat64Property(name)
+		if timeout == 0 {
+			timeout = jobTimeout
+		}
+		return time.Duration(timeout * float64(time.Second))
 	}
+	return jobLimits{queue: limit("queueTimeout"), run: limit("runTimeout")}
+}
 
-	// Submit the Job
-	jt.SetExtension("uge_jt_pe", p.resources.GetStringProperty("ugeParallelEnvironment"))
-	log.Printf("Submit (%d-%d) %s %s %v\n ", jt.MinSlots, jt.MaxSlots, j.Name, j.Executable, jt.Args)
-	if jobInfo, err = p.js.RunJob(jt); err != nil {
-		return nil, err
+// reattach returns the job that a previous driver submitted with the same command
+// if the grid has not yet finished it or if the job completed successfully
+func (p *GridProcessor) reattach(jt JobTemplate, fingerprint string) (*JobInfo, bool) {
+	id, found := p.state.lookup(jt.JobName, fingerprint)
+	if !found {
+		return nil, false
 	}
-	log.Printf("Submitted job %s\n", jobInfo.ID)
-	_, err = waitForState(jobInfo, p.js, Running, jTimeout)
-	gji := GridJobInfo{
-		js:              p.js,
-		jt:              &jt,
-		jobInfo:         jobInfo,
-		jobTimeoutInSec: jTimeout,
+	jobInfo := &JobInfo{ID: id}
+	if err := p.js.UpdateJobInfo(jobInfo); err != nil {
+		log.Printf("Cannot reattach to job %s of %s: %v", id, jt.JobName, err)
+		p.state.finished(jt.JobName, id)
+		return nil, false
+	}
+	switch jobInfo.State {
+	case Queued, QueuedHeld, Requeued, RequeuedHeld, Running, Suspended, Done:
+		log.Printf("Reattach to job %s of %s - %s", id, jt.JobName, jobInfo.State)
+		return jobInfo, true
+	}
+	log.Printf("Resubmit %s since job %s is %s", jt.JobName, id, jobInfo.State)
+	p.state.finished(jt.JobName, id)
+	return nil, false
+}
+
+// submitted starts tracking a job that was submitted to the grid
+func (p *GridProcessor) submitted(jt JobTemplate, jobInfo *JobInfo, tracker *process.JobTracker, fingerprint, stagingScript string) GridJobInfo {
+	if jobInfo.SubmissionTime.IsZero() {
+		jobInfo.SubmissionTime = time.Now()
+	}
+	tracker.Submitted(jobInfo.ID)
+	p.active.add(jobInfo.ID)
+	p.state.submitted(jt.JobName, jobInfo.ID, fingerprint)
+	return GridJobInfo{
+		js:         p.js,
+		jt:         &jt,
+		jobInfo:    jobInfo,
+		limits:     p.jobLimits(),
+		poller:     p.poller,
+		tracker:    tracker,
+		accounting: p.accounting,
+		active:     p.active,
+		state:      p.state,
+
+		stagingScript: stagingScript,
+	}
+}
+
+// ControlJobs applies the action to all the jobs submitted by the processor that have not completed yet.
+// The returned JobErrors contains an entry for every job the action could not be applied to.
+func (p *GridProcessor) ControlJobs(action process.JobAction) error {
+	var errs process.JobErrors
+	for _, id := range p.active.list() {
+		log.Printf("%s job %s", action, id)
+		if err := p.js.ControlJob(&JobInfo{ID: id}, action); err != nil {
+			errs = append(errs, &process.JobError{Name: id, Err: err})
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// jobLimits the maximum time a grid job may wait in the queue and the maximum time it may run; 0 means no limit
+type jobLimits struct {
+	queue time.Duration
+	run   time.Duration
+}
+
+// jobDeadline tracks the time limit of the stage a job is in. The queue limit applies from the job's
+// submission or release and the run limit from the job's dispatch; held jobs have no limit.
+type jobDeadline struct {
+	limits jobLimits
+	phase  JobState // Unset, Queued, QueuedHeld or Running
+	stage  process.TimeoutStage
+	limit  time.Duration
+	timer  *time.Timer
+}
+
+// update restarts the timer when the job moved to a different stage
+func (d *jobDeadline) update(ji *JobInfo) {
+	var phase JobState
+	switch ji.State {
+	case Unset, Queued, Requeued:
+		phase = Queued
+	case QueuedHeld, RequeuedHeld:
+		phase = QueuedHeld
+	case Running, Suspended:
+		phase = Running
+	default:
+		// the job completed or its state is not known
+		return
+	}
+	if phase == d.phase {
+		return
+	}
+	previousPhase := d.phase
+	d.phase = phase
+	d.stop()
+	switch phase {
+	case Queued:
+		since := time.Now()
+		if previousPhase == Unset && !ji.SubmissionTime.IsZero() {
+			since = ji.SubmissionTime
+		}
+		d.start(process.QueueTimeout, d.limits.queue, since)
+	case Running:
+		if ji.DispatchTime.IsZero() {
+			// the grid does not report the dispatch time so use the time the job was first seen running
+			ji.DispatchTime = time.Now()
+		}
+		d.start(process.RunTimeout, d.limits.run, ji.DispatchTime)
+	}
+}
+
+func (d *jobDeadline) start(stage process.TimeoutStage, limit time.Duration, since time.Time) {
+	if limit <= 0 {
+		return
+	}
+	d.stage = stage
+	d.limit = limit
+	d.timer = time.NewTimer(time.Until(since.Add(limit)))
+}
+
+func (d *jobDeadline) stop() {
+	if d.timer != nil {
+		d.timer.Stop()
+		d.timer = nil
 	}
-	return gji, err
 }
 
-func waitForState(ji *JobInfo, js DRMAASession, desiredState JobState, waitTimeoutInSec int64) (bool, error) {
-	quit := make(chan struct{})
-	if waitTimeoutInSec > 0 {
-		to := time.Duration(waitTimeoutInSec * int64(time.Second))
-		time.AfterFunc(to, func() {
-			var q struct{}
-			quit <- q
-		})
+// expired returns the channel that receives the time when the stage's limit is exceeded
+func (d *jobDeadline) expired() <-chan time.Time {
+	if d.timer == nil {
+		return nil
 	}
-	pollingInterval := time.Duration(30 * time.Second)
+	return d.timer.C
+}
+
+// waitForState waits for the session poller to report that the job reached the desired state, the job terminated or the wait timed out.
+// If the job exceeds its queue or run limit or if the context is cancelled while waiting the job is terminated.
+// The observed transitions are reported to the job's tracker.
+func waitForState(ctx context.Context, ji *JobInfo, js DRMAASession, poller *sessionPoller, tracker *process.JobTracker,
+	desiredState JobState, limits jobLimits) (bool, error) {
+	deadline := jobDeadline{limits: limits}
+	defer deadline.stop()
+	deadline.update(ji)
+	updates, stopWatching := poller.watch(ji)
+	defer stopWatching()
 	for {
 		select {
-		case <-time.After(pollingInterval):
-			jobStatus, err := checkJobState(ji, js)
-			if err != nil {
-				return false, fmt.Errorf("Error getting job %s status %v", ji.ID, err)
+		case u := <-updates:
+			*ji = u.info
+			if u.err != nil {
+				return false, &SchedulerError{Op: "getting job " + ji.ID + " status", Err: u.err}
+			}
+			deadline.update(ji)
+			jobStatus := ji.State
+			switch jobStatus {
+			case Queued, QueuedHeld, Requeued, RequeuedHeld:
+				tracker.Queued()
+			case Running, Done:
+				tracker.Running(strings.Join(ji.AllocatedMachines, ","))
 			}
 			if desiredState != Unset && desiredState == jobStatus {
 				return true, nil
@@ -186,27 +573,64 @@ func waitForState(ji *JobInfo, js DRMAASession, desiredState JobState, waitTimeo
 			case Done:
 				return false, nil
 			case Failed:
-				return false, fmt.Errorf("Job %s failed", ji.ID)
+				return false, &JobFailedError{ID: ji.ID}
+			}
+		case <-deadline.expired():
+			log.Printf("Terminate job %s after the %s limit of %v", ji.ID, deadline.stage, deadline.limit)
+			if err := js.ControlJob(ji, process.Terminate); err != nil {
+				log.Printf("Error terminating job %s: %v", ji.ID, err)
+			}
+			return false, &process.TimeoutError{Name: ji.ID, Limit: deadline.limit, Stage: deadline.stage}
+		case <-ctx.Done():
+			log.Printf("Terminate job %s: %v", ji.ID, ctx.Err())
+			if err := js.ControlJob(ji, process.Terminate); err != nil {
+				log.Printf("Error terminating job %s: %v", ji.ID, err)
 			}
-		case <-quit:
-			return false, fmt.Errorf("Job %s timeout", ji.ID)
+			return false, ctx.Err()
 		}
 	}
 }
 
-func checkJobState(ji *JobInfo, js DRMAASession) (JobState, error) {
-	if err := js.UpdateJobInfo(ji); err != nil {
-		return Undetermined, err
+// CloseSession close the processing session
+func (p *GridProcessor) CloseSession() error {
+	_, err := p.closeSession()
+	return err
+}
+
+// DestroySession closes the processing session and, once no other processor uses the session, destroys it
+// together with its saved job state so that a later run with the same session name does not reattach to its jobs
+func (p *GridProcessor) DestroySession() error {
+	last, err := p.closeSession()
+	if err != nil || !last {
+		return err
+	}
+	log.Println("Destroy session ", p.sessionName)
+	if jobStateDir := p.resources.GetStringProperty("jobStateDir"); jobStateDir != "" {
+		if err = removeSessionJobState(jobStateDir, p.sessionName); err != nil {
+			return err
+		}
+	}
+	if sd, ok := p.dp.(DRMAASessionDestroyer); ok {
+		return sd.DestroySession(p.sessionName)
 	}
-	return ji.State, nil
+	return nil
 }
 
-// CloseSession close the processing session
-func (p *GridProcessor) CloseSession() error {
+// closeSession closes the processor's session and reports whether it was the last processor that used the session
+func (p *GridProcessor) closeSession() (bool, error) {
+	last := releaseSession(p.sessionName)
+	if reportDir := process.ReportDir(p.resources); reportDir != "" {
+		if err := p.accounting.write(reportDir); err != nil {
+			log.Print(err)
+		}
+	}
+	if err := p.state.close(); err != nil {
+		log.Printf("Error closing the job state of %s: %v", p.sessionName, err)
+	}
 	log.Println("Close session ", p.sessionName)
 	if closeJsErr := p.js.Close(); closeJsErr != nil {
 		log.Printf("Close session error %v", closeJsErr)
-		return closeJsErr
+		return last, closeJsErr
 	}
-	return nil
+	return last, nil
 }
@@ -0,0 +1,542 @@
+package drmaautils
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"arg"
+	"config"
+	"process"
+)
+
+// batchSession counts the requests sent to a simulated session
+type batchSession struct {
+	*SimulatedSession
+	mu           sync.Mutex
+	jobRequests  int
+	maxBatchSize int
+}
+
+func (bs *batchSession) UpdateJobInfo(j *JobInfo) error {
+	bs.mu.Lock()
+	bs.jobRequests++
+	bs.mu.Unlock()
+	return bs.SimulatedSession.UpdateJobInfo(j)
+}
+
+func (bs *batchSession) UpdateJobsInfo(jobs []*JobInfo) []error {
+	bs.mu.Lock()
+	if len(jobs) > bs.maxBatchSize {
+		bs.maxBatchSize = len(jobs)
+	}
+	bs.mu.Unlock()
+	errs := make([]error, len(jobs))
+	for i, j := range jobs {
+		errs[i] = bs.SimulatedSession.UpdateJobInfo(j)
+	}
+	return errs
+}
+
+func TestGridProcessorPollsAllJobsTogether(t *testing.T) {
+	p, outputDir := newSimulatedProcessor(t, &SimulatedProxy{QueueDelay: 50 * time.Millisecond, RunTime: 50 * time.Millisecond, DryRun: true})
+	defer os.RemoveAll(outputDir)
+	bs := &batchSession{SimulatedSession: p.js.(*SimulatedSession)}
+	p.js = bs
+	p.poller = newSessionPoller(bs, 10*time.Millisecond)
+
+	const nJobs = 10
+	var wg sync.WaitGroup
+	for i := 0; i < nJobs; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := p.Run(context.Background(), process.Job{Name: fmt.Sprintf("job_%d", i), CmdlineBuilder: shCmdlineBuilder{}}); err != nil {
+				t.Error("Unexpected error", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if bs.jobRequests != 0 {
+		t.Error("Expected the jobs to be polled together but", bs.jobRequests, "jobs were polled individually")
+	}
+	if bs.maxBatchSize < 2 {
+		t.Error("Expected the jobs to be polled together but the largest batch had", bs.maxBatchSize, "jobs")
+	}
+}
+
+func TestSessionPollerPollsRightAway(t *testing.T) {
+	p, outputDir := newSimulatedProcessor(t, &SimulatedProxy{DryRun: true})
+	defer os.RemoveAll(outputDir)
+	ji, err := p.js.RunJob(JobTemplate{JobName: "polled", RemoteCommand: "true"})
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	updates, stopWatching := newSessionPoller(p.js, time.Hour).watch(ji)
+	defer stopWatching()
+	select {
+	case u := <-updates:
+		if u.err != nil {
+			t.Error("Unexpected error", u.err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("Expected the job to be polled as soon as it is watched")
+	}
+}
+
+func TestSessionPollingIntervalDependsOnBatching(t *testing.T) {
+	ss := &SimulatedSession{}
+	if interval := sessionPollingInterval(ss, config.Config{}); interval != 30*time.Second {
+		t.Error("Expected the jobs of a session that cannot batch to be polled every 30s but got", interval)
+	}
+	if interval := sessionPollingInterval(&batchSession{SimulatedSession: ss}, config.Config{}); interval != 10*time.Second {
+		t.Error("Expected the jobs of a batch session to be polled every 10s but got", interval)
+	}
+	if interval := sessionPollingInterval(ss, config.Config{"jobPollingInterval": 5}); interval != 5*time.Second {
+		t.Error("Expected the configured polling interval but got", interval)
+	}
+}
+
+func TestGridProcessorReleasesHeldJobs(t *testing.T) {
+	p, outputDir := newSimulatedProcessor(t, &SimulatedProxy{RunTime: 10 * time.Millisecond, DryRun: true})
+	defer os.RemoveAll(outputDir)
+	p.resources["submitAsHold"] = true
+
+	ji, err := p.Start(context.Background(), process.Job{Name: "held", CmdlineBuilder: shCmdlineBuilder{}})
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	terminated := make(chan error, 1)
+	go func() {
+		terminated <- ji.WaitForTermination(context.Background())
+	}()
+	time.Sleep(100 * time.Millisecond)
+	select {
+	case err := <-terminated:
+		t.Fatal("Expected the held job to wait for its release but it terminated", err)
+	default:
+	}
+	if err := p.ControlJobs(process.Suspend); err == nil {
+		t.Error("Expected the queued job not to be suspended")
+	}
+	if err := p.ControlJobs(process.Release); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	select {
+	case err := <-terminated:
+		if err != nil {
+			t.Error("Unexpected error", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("The released job did not complete")
+	}
+}
+
+func TestParallelProcessorSubmitsAllSubjobsHeld(t *testing.T) {
+	p, outputDir := newSimulatedProcessor(t, &SimulatedProxy{RunTime: 10 * time.Millisecond, DryRun: true})
+	defer os.RemoveAll(outputDir)
+	p.resources["submitAsHold"] = true
+
+	const nJobs = 5
+	pp := process.NewParallelProcessor(p, echoSplitter{nJobs}, config.Config{
+		"maxRunningJobs": 2,
+		"submitAsHold":   true,
+	})
+	done := make(chan error, 1)
+	go func() {
+		done <- pp.Run(context.Background(), process.Job{Name: "stage"})
+	}()
+	for i := 0; len(p.active.list()) < nJobs; i++ {
+		if i >= 500 {
+			t.Fatalf("Expected all %d subjobs to be submitted held but only %d were", nJobs, len(p.active.list()))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	select {
+	case err := <-done:
+		t.Fatal("Expected the held subjobs to wait for their release but the job completed", err)
+	default:
+	}
+	if err := p.ControlJobs(process.Release); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error("Unexpected error", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("The released subjobs did not complete")
+	}
+}
+
+// echoSplitter splits a job into nJobs subjobs that echo their index
+type echoSplitter struct {
+	nJobs int
+}
+
+func (s echoSplitter) SplitJob(j process.Job, jch chan<- process.Job) error {
+	for i := 0; i < s.nJobs; i++ {
+		jch <- process.Job{
+			Executable:     "sh",
+			Name:           fmt.Sprintf("%s_%d", j.Name, i),
+			CmdlineBuilder: shCmdlineBuilder{script: fmt.Sprintf("echo task %d", i)},
+		}
+	}
+	return nil
+}
+
+func TestGridProcessorSubmitsBulkJobs(t *testing.T) {
+	p, outputDir := newSimulatedProcessor(t, &SimulatedProxy{})
+	defer os.RemoveAll(outputDir)
+	pp := process.NewParallelProcessor(p, echoSplitter{6}, config.Config{"bulkJobSize": 4})
+	if err := pp.Run(context.Background(), process.Job{Name: "bulk"}); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	taskScripts, _ := filepath.Glob(filepath.Join(outputDir, "*.tasks.sh"))
+	if len(taskScripts) != 2 {
+		t.Errorf("Expected 2 task scripts but found %v", taskScripts)
+	}
+	outputs, _ := filepath.Glob(filepath.Join(outputDir, "*.o*"))
+	found := map[string]bool{}
+	for _, o := range outputs {
+		content, err := ioutil.ReadFile(o)
+		if err != nil {
+			t.Fatal(err)
+		}
+		found[strings.TrimSpace(string(content))] = true
+	}
+	for i := 0; i < 6; i++ {
+		if !found[fmt.Sprintf("task %d", i)] {
+			t.Errorf("No output found for task %d in %v", i, outputs)
+		}
+	}
+}
+
+func TestGridProcessorSubmitsBulkJobsThroughDecorators(t *testing.T) {
+	p, outputDir := newSimulatedProcessor(t, &SimulatedProxy{})
+	defer os.RemoveAll(outputDir)
+	decorated := process.DecorateProcessor(p,
+		process.RetryProcessing(config.Config{"maxJobAttempts": 3}),
+		process.CacheProcessing(config.Config{"jobCacheDir": filepath.Join(outputDir, "cache")}))
+	pp := process.NewParallelProcessor(decorated, echoSplitter{6}, config.Config{"bulkJobSize": 4})
+	if err := pp.Run(context.Background(), process.Job{Name: "bulk"}); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	taskScripts, _ := filepath.Glob(filepath.Join(outputDir, "*.tasks.sh"))
+	if len(taskScripts) != 2 {
+		t.Errorf("Expected 2 task scripts but found %v", taskScripts)
+	}
+}
+
+// slotsSplitter splits a job into subjobs that echo their index and alternately request one and two slots
+type slotsSplitter struct {
+	echoSplitter
+}
+
+func (s slotsSplitter) SplitJob(j process.Job, jch chan<- process.Job) error {
+	ech := make(chan process.Job)
+	go func() {
+		s.echoSplitter.SplitJob(j, ech)
+		close(ech)
+	}()
+	i := 0
+	for sj := range ech {
+		sj.Requirements.Slots = 1 + i%2
+		jch <- sj
+		i++
+	}
+	return nil
+}
+
+func TestGridProcessorGroupsBulkJobsByRequirements(t *testing.T) {
+	p, outputDir := newSimulatedProcessor(t, &SimulatedProxy{})
+	defer os.RemoveAll(outputDir)
+	pp := process.NewParallelProcessor(p, slotsSplitter{echoSplitter{4}}, config.Config{"bulkJobSize": 4})
+	if err := pp.Run(context.Background(), process.Job{Name: "bulk"}); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	taskScripts, _ := filepath.Glob(filepath.Join(outputDir, "*.tasks.sh"))
+	if len(taskScripts) != 2 {
+		t.Fatalf("Expected an array job for every requirements but found %v", taskScripts)
+	}
+	for _, taskScript := range taskScripts {
+		content, err := ioutil.ReadFile(taskScript)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := strings.Count(string(content), "exec "); n != 2 {
+			t.Errorf("Expected 2 tasks in %s but found %d", taskScript, n)
+		}
+	}
+}
+
+func TestGridJobTemplateUsesJobRequirements(t *testing.T) {
+	p, outputDir := newSimulatedProcessor(t, &SimulatedProxy{DryRun: true})
+	defer os.RemoveAll(outputDir)
+	p.resources["ugeQueue"] = "default"
+	p.resources["ugeMinSlots"] = 8
+	p.resources["ugeMaxSlots"] = 8
+	p.resources["ugeMemoryResource"] = "mem_free"
+	p.resources["jobProfiles"] = map[string]interface{}{
+		"dmgServer": map[string]interface{}{
+			"slots":        1.0,
+			"memory":       2048.0,
+			"queue":        "short",
+			"runtimeLimit": 3600.0,
+			"nativeSpec":   "-l sandy=true",
+		},
+	}
+
+	jt, err := p.jobTemplate(process.Job{
+		Name:           "server",
+		CmdlineBuilder: shCmdlineBuilder{},
+		Requirements:   process.ResourceRequirements{Role: "dmgServer"},
+	})
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if jt.QueueName != "short" || jt.MinSlots != 1 || jt.MaxSlots != 1 {
+		t.Errorf("Expected the profile's queue and slots but got %s %d-%d", jt.QueueName, jt.MinSlots, jt.MaxSlots)
+	}
+	if jt.ResourceLimits["mem_free"] != "2048M" || jt.ResourceLimits["h_rt"] != "3600" {
+		t.Errorf("Expected the profile's memory and runtime limit but got %v", jt.ResourceLimits)
+	}
+	if jt.GetExtension("uge_jt_native") != "-l sandy=true" {
+		t.Errorf("Expected the profile's native spec but got %q", jt.GetExtension("uge_jt_native"))
+	}
+
+	jt, err = p.jobTemplate(process.Job{
+		Name:           "client",
+		CmdlineBuilder: shCmdlineBuilder{},
+		Requirements:   process.ResourceRequirements{Slots: 16, Role: "dmgClient"},
+	})
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if jt.QueueName != "default" || jt.MinSlots != 16 || jt.MaxSlots != 16 {
+		t.Errorf("Expected the job's slots in the default queue but got %s %d-%d", jt.QueueName, jt.MinSlots, jt.MaxSlots)
+	}
+	if _, found := jt.ResourceLimits["h_rt"]; found {
+		t.Errorf("Expected no runtime limit but got %v", jt.ResourceLimits)
+	}
+}
+
+func TestGridProcessorReattachesAfterRestart(t *testing.T) {
+	sp := &SimulatedProxy{RunTime: 200 * time.Millisecond, DryRun: true}
+	p, outputDir := newSimulatedProcessor(t, sp)
+	defer os.RemoveAll(outputDir)
+	p.resources["jobStateDir"] = filepath.Join(outputDir, "state")
+	job := process.Job{Name: "long", CmdlineBuilder: shCmdlineBuilder{script: "sleep 1"}}
+
+	crashed, err := NewGridProcessor("test", "", sp, p.resources)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ji, err := crashed.Start(context.Background(), job)
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	submittedID := ji.(GridJobInfo).jobInfo.ID
+
+	restarted, err := NewGridProcessor("test", "", sp, p.resources)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ji, err = restarted.Start(context.Background(), job)
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if id := ji.(GridJobInfo).jobInfo.ID; id != submittedID {
+		t.Errorf("Expected the restarted processor to reattach to job %s but it submitted job %s", submittedID, id)
+	}
+	if err = ji.WaitForTermination(context.Background()); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	restarted.CloseSession()
+
+	rerun, err := NewGridProcessor("test", "", sp, p.resources)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rerun.CloseSession()
+	ji, err = rerun.Start(context.Background(), job)
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if id := ji.(GridJobInfo).jobInfo.ID; id == submittedID {
+		t.Error("Expected the finished job to be resubmitted")
+	}
+}
+
+func TestGridProcessorDestroysSessionAfterLastProcessor(t *testing.T) {
+	sp := &SimulatedProxy{DryRun: true}
+	p, outputDir := newSimulatedProcessor(t, sp)
+	defer os.RemoveAll(outputDir)
+	p.CloseSession()
+	stateDir := filepath.Join(outputDir, "state")
+	p.resources["jobStateDir"] = stateDir
+
+	dmgProcessor, err := NewGridProcessor("shared", "", sp, p.resources)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sectionProcessor, err := NewGridProcessor("shared", "", sp, p.resources)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = dmgProcessor.DestroySession(); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if _, found := sp.sessions["shared"]; !found {
+		t.Error("Expected the session to be kept while another processor uses it")
+	}
+	if _, err = os.Stat(sessionJobStateFile(stateDir, "shared")); err != nil {
+		t.Error("Expected the job state to be kept while another processor uses the session", err)
+	}
+	if err = sectionProcessor.DestroySession(); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if _, found := sp.sessions["shared"]; found {
+		t.Error("Expected the session to be destroyed")
+	}
+	if _, err = os.Stat(sessionJobStateFile(stateDir, "shared")); !os.IsNotExist(err) {
+		t.Error("Expected the job state to be removed but got", err)
+	}
+}
+
+func TestGridProcessorQueueAndRunTimeouts(t *testing.T) {
+	queued, outputDir := newSimulatedProcessor(t, &SimulatedProxy{QueueDelay: time.Hour, DryRun: true})
+	defer os.RemoveAll(outputDir)
+	queued.resources["queueTimeout"] = 0.05
+	_, err := queued.Start(context.Background(), process.Job{Name: "queued", CmdlineBuilder: shCmdlineBuilder{}})
+	var timeoutErr *process.TimeoutError
+	if !errors.As(err, &timeoutErr) || timeoutErr.Stage != process.QueueTimeout {
+		t.Fatal("Expected a queue timeout but got", err)
+	}
+	ss := queued.js.(*SimulatedSession)
+	ji := &JobInfo{ID: lastSimulatedJobID(ss)}
+	if err := ss.UpdateJobInfo(ji); err != nil || ji.State != Failed {
+		t.Error("Expected the job that timed out in the queue to be terminated but it is", ji.State, err)
+	}
+
+	running, outputDir := newSimulatedProcessor(t, &SimulatedProxy{QueueDelay: 100 * time.Millisecond, RunTime: time.Hour, DryRun: true})
+	defer os.RemoveAll(outputDir)
+	running.resources["queueTimeout"] = 5
+	running.resources["runTimeout"] = 0.05
+	err = running.Run(context.Background(), process.Job{Name: "running", CmdlineBuilder: shCmdlineBuilder{}})
+	if !errors.As(err, &timeoutErr) || timeoutErr.Stage != process.RunTimeout {
+		t.Fatal("Expected a run timeout but got", err)
+	}
+	ss = running.js.(*SimulatedSession)
+	ji = &JobInfo{ID: lastSimulatedJobID(ss)}
+	if err := ss.UpdateJobInfo(ji); err != nil || ji.State != Failed {
+		t.Error("Expected the job that ran too long to be terminated but it is", ji.State, err)
+	}
+}
+
+// scratchCopyCmdlineBuilder copies the "in" file to the "out" file and fails unless both are in the "temp" directory
+var scratchCopyCmdlineBuilder = shCmdlineBuilder{
+	script: `case "$1:$2" in "$3"/*:"$3"/*) cp "$1" "$2" ;; *) exit 2 ;; esac`,
+	args:   []string{"in", "out", "temp"},
+}
+
+// stagingSplitter creates a staged job for every band file in the shared directory
+type stagingSplitter struct {
+	sharedDir string
+	bands     []string
+}
+
+func (s stagingSplitter) SplitJob(j process.Job, jch chan<- process.Job) error {
+	for _, band := range s.bands {
+		fs := flag.NewFlagSet("staging", flag.ContinueOnError)
+		fs.String("in", filepath.Join(s.sharedDir, band), "")
+		fs.String("out", filepath.Join(s.sharedDir, "target", band), "")
+		fs.String("temp", "", "")
+		jch <- process.Job{
+			Executable:     "sh",
+			Name:           fmt.Sprintf("%s_%s", j.Name, band),
+			JArgs:          arg.Args{Flags: fs},
+			CmdlineBuilder: scratchCopyCmdlineBuilder,
+			Staging:        process.FileStaging{Inputs: []string{"in"}, Outputs: []string{"out"}, ScratchArg: "temp"},
+		}
+	}
+	return nil
+}
+
+func TestGridProcessorStagesFiles(t *testing.T) {
+	p, sharedDir := newSimulatedProcessor(t, &SimulatedProxy{})
+	defer os.RemoveAll(sharedDir)
+	scratchRoot := filepath.Join(sharedDir, "scratch")
+	p.resources["scratchDir"] = scratchRoot
+	bands := []string{"band0.iGrid", "band1.iGrid", "band2.iGrid"}
+	for _, band := range bands {
+		if err := ioutil.WriteFile(filepath.Join(sharedDir, band), []byte(band), 0664); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	single := process.NewParallelProcessor(p, stagingSplitter{sharedDir, bands[:1]}, config.Config{})
+	if err := single.Run(context.Background(), process.Job{Name: "single"}); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	bulk := process.NewParallelProcessor(p, stagingSplitter{sharedDir, bands[1:]}, config.Config{"bulkJobSize": 2})
+	if err := bulk.Run(context.Background(), process.Job{Name: "bulk"}); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	for _, band := range bands {
+		content, err := ioutil.ReadFile(filepath.Join(sharedDir, "target", band))
+		if err != nil || string(content) != band {
+			t.Errorf("Expected %s to be staged out but got %q %v", band, content, err)
+		}
+	}
+	if stagingScripts, _ := filepath.Glob(filepath.Join(sharedDir, "*.staging.sh")); len(stagingScripts) > 0 {
+		t.Errorf("Expected the staging scripts to be removed once the jobs completed but found %v", stagingScripts)
+	}
+	if left, _ := ioutil.ReadDir(filepath.Join(scratchRoot, "test")); len(left) > 0 {
+		t.Errorf("Expected the scratch directories to be removed but found %d", len(left))
+	}
+}
+
+// fileStagingSimulatedSession a simulated session that claims to transfer the staged files itself like a DRMAA2 session
+type fileStagingSimulatedSession struct {
+	DRMAASession
+}
+
+func (fileStagingSimulatedSession) stagesFiles() {
+}
+
+func TestGridProcessorLeavesStagingToSessionsThatStageFiles(t *testing.T) {
+	p, sharedDir := newSimulatedProcessor(t, &SimulatedProxy{DryRun: true})
+	defer os.RemoveAll(sharedDir)
+	p.resources["scratchDir"] = filepath.Join(sharedDir, "scratch")
+	p.js = fileStagingSimulatedSession{p.js}
+	jch := make(chan process.Job, 1)
+	stagingSplitter{sharedDir, []string{"band0.iGrid"}}.SplitJob(process.Job{Name: "staged"}, jch)
+
+	jt, err := p.jobTemplate(<-jch)
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if _, err = p.stageThroughScript(&jt); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if jt.RemoteCommand != "sh" {
+		t.Errorf("Expected the job's command to be left unchanged but got %s %v", jt.RemoteCommand, jt.Args)
+	}
+	scratchDir := p.jobScratchDir(jt.JobName)
+	if jt.StageInFiles[filepath.Join(sharedDir, "band0.iGrid")] != filepath.Join(scratchDir, "in0-band0.iGrid") {
+		t.Errorf("Expected the input to be staged to %s but got %v", scratchDir, jt.StageInFiles)
+	}
+	if jt.StageOutFiles[filepath.Join(scratchDir, "out0-band0.iGrid")] != filepath.Join(sharedDir, "target", "band0.iGrid") {
+		t.Errorf("Expected the output to be staged from %s but got %v", scratchDir, jt.StageOutFiles)
+	}
+}
@@ -1,3 +1,6 @@
+//go:build !nodrmaa
+// +build !nodrmaa
+
 package drmaautils
 
 import (
@@ -6,16 +9,20 @@ import (
 	"github.com/dgruber/drmaa"
 	"log"
 	"os"
+	"process"
 	"strconv"
+	"sync"
+	"time"
 )
 
 // DRMAAV1Proxy - drmaa1 proxy
 type DRMAAV1Proxy struct {
 }
 
-// DRMAAV1Session - drmaa1 session
+// DRMAAV1Session - handle of the drmaa1 session. Every CreateSession returns a new handle of the shared session.
 type DRMAAV1Session struct {
-	js *drmaa.Session
+	js        *drmaa.Session
+	closeOnce sync.Once
 }
 
 // NewDRMAAV1Proxy create a new drmaa2 proxy
@@ -23,69 +30,135 @@ func NewDRMAAV1Proxy() DRMAAProxy {
 	return &DRMAAV1Proxy{}
 }
 
-var drmaaV1Session DRMAASession
+// drmaaV1 the DRMAA v1 library supports a single session per process so all the proxies share it;
+// refs counts the open handles of the session
+var drmaaV1 struct {
+	mu   sync.Mutex
+	js   *drmaa.Session
+	refs int
+}
+
+// drmaaJobIDsSessionAny the job ID that waits for any job of the session
+const drmaaJobIDsSessionAny = "DRMAA_JOB_IDS_SESSION_ANY"
 
-// CreateSession DRMAAProxy method
+// drmaaV1Finished the jobs that were reaped from the shared session but not updated yet indexed by the job ID.
+// A job can only be reaped once so the handle that reaps it keeps its info for the handle that submitted it.
+var drmaaV1Finished = struct {
+	mu   sync.Mutex
+	jobs map[string]drmaa.JobInfo
+}{jobs: make(map[string]drmaa.JobInfo)}
+
+func init() {
+	process.RegisterProcessor("drmaa1", func(params process.ProcessorParams) (process.Processor, error) {
+		return NewGridProcessor(params.SessionName, params.AccountID, NewDRMAAV1Proxy(), params.Resources)
+	})
+}
+
+func (d1p *DRMAAV1Proxy) processorType() string {
+	return "drmaa1"
+}
+
+// CreateSession DRMAAProxy method. The session is initialized by the first call; since the session
+// is shared, the name passed to the following calls is ignored.
 func (d1p *DRMAAV1Proxy) CreateSession(name string) (DRMAASession, error) {
-	var js drmaa.Session
-	var err error
-
-	if drmaaV1Session != nil {
-		return drmaaV1Session, nil
-	}
-	sessionName := "session=" + name
-	if err = js.Init(sessionName); err != nil {
-		drmaaErr := err.(*drmaa.Error)
-		switch drmaaErr.ID {
-		case drmaa.AlreadyActiveSession:
-			log.Printf("A DRMAA session is already active, continue using the current session")
-			break
-		default:
-			return nil, fmt.Errorf("Could not open DRMAA v1 session: %v:%v", drmaaErr.ID, err)
+	drmaaV1.mu.Lock()
+	defer drmaaV1.mu.Unlock()
+	if drmaaV1.js == nil {
+		var js drmaa.Session
+		sessionName := "session=" + name
+		if err := js.Init(sessionName); err != nil {
+			drmaaErr := err.(*drmaa.Error)
+			switch drmaaErr.ID {
+			case drmaa.AlreadyActiveSession:
+				log.Printf("A DRMAA session is already active, continue using the current session")
+			default:
+				return nil, fmt.Errorf("Could not open DRMAA v1 session: %v:%v", drmaaErr.ID, err)
+			}
 		}
+		drmaaV1.js = &js
 	}
-	drmaaV1Session = &DRMAAV1Session{&js}
-	return drmaaV1Session, nil
+	drmaaV1.refs++
+	return &DRMAAV1Session{js: drmaaV1.js}, nil
 }
 
 // RunJob DRMAASession method
 func (d1s *DRMAAV1Session) RunJob(jt JobTemplate) (*JobInfo, error) {
-	djt, err := d1s.js.AllocateJobTemplate()
+	djt, err := d1s.createJobTemplate(jt)
+	if err != nil {
+		return nil, err
+	}
+	defer d1s.js.DeleteJobTemplate(&djt)
+	jobID, err := d1s.js.RunJob(&djt)
+	if err != nil {
+		return nil, fmt.Errorf("Error submitting job %v", err)
+	}
+	return &JobInfo{ID: jobID}, nil
+}
+
+// RunBulkJobs DRMAABulkSession method
+func (d1s *DRMAAV1Session) RunBulkJobs(jt JobTemplate, begin, end, step int) ([]*JobInfo, error) {
+	djt, err := d1s.createJobTemplate(jt)
 	if err != nil {
-		return nil, fmt.Errorf("Error allocating a new job template: %v", err)
+		return nil, err
 	}
 	defer d1s.js.DeleteJobTemplate(&djt)
-	if err := djt.SetJobName(jt.JobName); err != nil {
-		return nil, fmt.Errorf("Error during SetJobName %s: %v", jt.JobName, err)
+	jobIDs, err := d1s.js.RunBulkJobs(&djt, begin, end, step)
+	if err != nil {
+		return nil, fmt.Errorf("Error submitting bulk job %v", err)
+	}
+	tasks := make([]*JobInfo, len(jobIDs))
+	for i, jobID := range jobIDs {
+		tasks[i] = &JobInfo{ID: jobID}
+	}
+	return tasks, nil
+}
+
+// createJobTemplate allocates a DRMAA v1 job template and sets it from the given template
+func (d1s *DRMAAV1Session) createJobTemplate(jt JobTemplate) (djt drmaa.JobTemplate, err error) {
+	if djt, err = d1s.js.AllocateJobTemplate(); err != nil {
+		return djt, fmt.Errorf("Error allocating a new job template: %v", err)
+	}
+	defer func() {
+		if err != nil {
+			d1s.js.DeleteJobTemplate(&djt)
+		}
+	}()
+	if err = djt.SetJobName(jt.JobName); err != nil {
+		return djt, fmt.Errorf("Error during SetJobName %s: %v", jt.JobName, err)
 	}
 	if err = djt.SetRemoteCommand(jt.RemoteCommand); err != nil {
-		return nil, fmt.Errorf("Error setting remote command %s: %v", jt.RemoteCommand, err)
+		return djt, fmt.Errorf("Error setting remote command %s: %v", jt.RemoteCommand, err)
 	}
 	if err = djt.SetArgs(jt.Args); err != nil {
-		return nil, fmt.Errorf("Error setting args %v: %v", jt.Args, err)
+		return djt, fmt.Errorf("Error setting args %v: %v", jt.Args, err)
 	}
 	if err = djt.SetWD(jt.WorkingDirectory); err != nil {
-		return nil, fmt.Errorf("Error setting working directory %v: %v", jt.WorkingDirectory, err)
+		return djt, fmt.Errorf("Error setting working directory %v: %v", jt.WorkingDirectory, err)
+	}
+	if jt.SubmitAsHold {
+		if err = djt.SetJobSubmissionState(drmaa.HoldState); err != nil {
+			return djt, fmt.Errorf("Error setting the hold state for %s: %v", jt.JobName, err)
+		}
 	}
 	if jt.InputPath != "" {
 		if err = djt.SetInputPath(":" + jt.InputPath); err != nil {
-			return nil, fmt.Errorf("Error setting input directory %v: %v", jt.InputPath, err)
+			return djt, fmt.Errorf("Error setting input directory %v: %v", jt.InputPath, err)
 		}
 	}
 	if jt.OutputPath != "" {
 		if err = os.MkdirAll(jt.OutputPath, os.ModePerm); err != nil {
-			return nil, fmt.Errorf("Error creating output directory %v: %v", jt.OutputPath, err)
+			return djt, fmt.Errorf("Error creating output directory %v: %v", jt.OutputPath, err)
 		}
 		if err = djt.SetOutputPath(":" + jt.OutputPath); err != nil {
-			return nil, fmt.Errorf("Error setting output directory %v: %v", jt.OutputPath, err)
+			return djt, fmt.Errorf("Error setting output directory %v: %v", jt.OutputPath, err)
 		}
 	}
 	if jt.ErrorPath != "" {
 		if err = os.MkdirAll(jt.ErrorPath, os.ModePerm); err != nil {
-			return nil, fmt.Errorf("Error creating error directory %v: %v", jt.ErrorPath, err)
+			return djt, fmt.Errorf("Error creating error directory %v: %v", jt.ErrorPath, err)
 		}
 		if err = djt.SetErrorPath(":" + jt.ErrorPath); err != nil {
-			return nil, fmt.Errorf("Error setting error directory %v: %v", jt.ErrorPath, err)
+			return djt, fmt.Errorf("Error setting error directory %v: %v", jt.ErrorPath, err)
 		}
 	}
 	var nativeSpecBuffer bytes.Buffer
@@ -93,100 +166,186 @@ func (d1s *DRMAAV1Session) RunJob(jt JobTemplate) (*JobInfo, error) {
 	appendPE(&nativeSpecBuffer, jt)
 	appendQueue(&nativeSpecBuffer, jt)
 	appendResourceLimits(&nativeSpecBuffer, jt)
+	appendNativeSpec(&nativeSpecBuffer, jt)
 
 	nativeSpec := nativeSpecBuffer.String()
 	if nativeSpec != "" {
 		if err = djt.SetNativeSpecification(nativeSpec); err != nil {
-			return nil, fmt.Errorf("Error setting args %v: %v", jt.Args, err)
+			return djt, fmt.Errorf("Error setting args %v: %v", jt.Args, err)
 		}
 	}
-
-	jobID, err := d1s.js.RunJob(&djt)
-	if err != nil {
-		return nil, fmt.Errorf("Error submitting job %v", err)
-	}
-	return &JobInfo{ID: jobID}, nil
+	return djt, nil
 }
 
-func appendAccountID(buf *bytes.Buffer, jt JobTemplate) {
-	if jt.AccountingID == "" {
-		return
-	}
-	buf.WriteString("-A ")
-	buf.WriteString(jt.AccountingID)
-	buf.WriteString(" ")
+// Close DRMAASession method. The shared session exits only when its last handle is closed
+// so that a processor does not close the session while another processor still uses it.
+// Closing a handle more than once has no effect.
+func (d1s *DRMAAV1Session) Close() (err error) {
+	d1s.closeOnce.Do(func() {
+		drmaaV1.mu.Lock()
+		defer drmaaV1.mu.Unlock()
+		drmaaV1.refs--
+		if drmaaV1.refs > 0 {
+			return
+		}
+		err = drmaaV1.js.Exit()
+		drmaaV1.js = nil
+	})
+	return err
 }
 
-func appendPE(buf *bytes.Buffer, jt JobTemplate) {
-	pe := jt.GetExtension("uge_jt_pe")
-	if pe == "" {
-		return
-	}
-	if jt.MinSlots == 0 && jt.MaxSlots == 0 {
-		return
-	}
-	buf.WriteString("-pe ")
-	buf.WriteString(pe)
-	buf.WriteString(" ")
-	if jt.MinSlots > 0 {
-		buf.WriteString(strconv.FormatInt(jt.MinSlots, 10))
-	}
-	if jt.MaxSlots > 0 {
-		buf.WriteString("-")
-		buf.WriteString(strconv.FormatInt(jt.MaxSlots, 10))
+// UpdateJobsInfo DRMAABatchSession method. It reaps all the jobs of the session that finished since the last update
+// by waiting for any job without blocking and then only queries the state of the jobs that were not seen running yet,
+// so the number of requests sent to the grid does not grow with the number of running jobs.
+func (d1s *DRMAAV1Session) UpdateJobsInfo(jobs []*JobInfo) []error {
+	d1s.reapFinishedJobs()
+	errs := make([]error, len(jobs))
+	for i, j := range jobs {
+		if j.State == Running && !d1s.updateFinished(j) {
+			// the job is still running since its completion would have been reaped
+			continue
+		}
+		errs[i] = d1s.UpdateJobInfo(j)
 	}
-	buf.WriteString(" ")
-	return
+	return errs
 }
 
-func appendQueue(buf *bytes.Buffer, jt JobTemplate) {
-	if jt.QueueName == "" {
-		return
+// reapFinishedJobs waits, without blocking, for all the jobs of the session that finished
+func (d1s *DRMAAV1Session) reapFinishedJobs() {
+	for {
+		jinfo, err := d1s.js.Wait(drmaaJobIDsSessionAny, drmaa.TimeoutNoWait)
+		if err != nil || jinfo.JobID() == "" {
+			// no other job finished
+			return
+		}
+		drmaaV1Finished.mu.Lock()
+		drmaaV1Finished.jobs[jinfo.JobID()] = jinfo
+		drmaaV1Finished.mu.Unlock()
 	}
-	buf.WriteString("-q ")
-	buf.WriteString(jt.QueueName)
-	buf.WriteString(" ")
 }
 
-func appendResourceLimits(buf *bytes.Buffer, jt JobTemplate) {
-	jobResourceLimits := jt.ResourceLimits
-	if len(jobResourceLimits) == 0 {
-		return
+// updateFinished updates the job if it was already reaped and reports whether it was
+func (d1s *DRMAAV1Session) updateFinished(j *JobInfo) bool {
+	drmaaV1Finished.mu.Lock()
+	jinfo, found := drmaaV1Finished.jobs[j.ID]
+	delete(drmaaV1Finished.jobs, j.ID)
+	drmaaV1Finished.mu.Unlock()
+	if !found {
+		return false
 	}
-	buf.WriteString("-l ")
-	lIndex := 0
-	for k, v := range jobResourceLimits {
-		if lIndex > 0 {
-			buf.WriteString(",")
-		}
-		buf.WriteString(k)
-		buf.WriteString("=")
-		buf.WriteString(v)
-		lIndex++
+	if jinfo.HasExited() && jinfo.ExitStatus() == 0 {
+		j.State = Done
+	} else {
+		j.State = Failed
 	}
-	buf.WriteString(" ")
-}
-
-// Close DRMAASession method. So far it has not been an issue with multiple proxies closing the session
-// while it's being used by another proxy. If this happens we need some counter to track the number of times
-// the session is "created"
-func (d1s *DRMAAV1Session) Close() error {
-	err := d1s.js.Exit()
-	drmaaV1Session = nil
-	return err
+	updateResourceUsage(j, jinfo)
+	return true
 }
 
 // UpdateJobInfo DRMAASession method
 func (d1s *DRMAAV1Session) UpdateJobInfo(j *JobInfo) error {
+	if j.State == Done || j.State == Failed {
+		// the job completed and it was already reaped when its resource usage was retrieved
+		return nil
+	}
+	if d1s.updateFinished(j) {
+		return nil
+	}
 	state, err := d1s.js.JobPs(j.ID)
 	if err != nil {
 		j.State = Undetermined
 		return err
 	}
 	j.State = convertPsToDRMAAState(state)
+	if j.State == Done || j.State == Failed {
+		if d1s.updateFinished(j) {
+			// another handle reaped the job in the meantime
+			return nil
+		}
+		// the resource usage is only available once the job completed
+		jinfo, err := d1s.js.Wait(j.ID, drmaa.TimeoutNoWait)
+		if err != nil {
+			log.Printf("Error retrieving the resource usage of job %s: %v", j.ID, err)
+			return nil
+		}
+		updateResourceUsage(j, jinfo)
+	}
 	return nil
 }
 
+// updateResourceUsage copies the exit status and the resource usage reported by DRMAA v1.
+// DRMAA v1 does not report the execution hosts so they are looked up in the grid engine's accounting.
+func updateResourceUsage(j *JobInfo, jinfo drmaa.JobInfo) {
+	if jinfo.HasExited() {
+		j.ExitStatus = int(jinfo.ExitStatus())
+	}
+	if jinfo.HasSignaled() {
+		j.TerminatingSignal = jinfo.TerminationSignal()
+	}
+	rusage := jinfo.ResourceUsage()
+	if v, ok := rusageValue(rusage, "ru_wallclock"); ok {
+		j.WallclockTime = time.Duration(v * float64(time.Second))
+	}
+	if v, ok := rusageValue(rusage, "cpu"); ok {
+		j.CPUTime = int64(v)
+	}
+	if t, ok := rusageTime(rusage, "submission_time"); ok {
+		j.SubmissionTime = t
+	}
+	if t, ok := rusageTime(rusage, "start_time"); ok {
+		j.DispatchTime = t
+	}
+	if t, ok := rusageTime(rusage, "end_time"); ok {
+		j.FinishTime = t
+	}
+	if len(j.AllocatedMachines) == 0 {
+		j.AllocatedMachines = executionHosts(j.ID)
+	}
+}
+
+func rusageValue(rusage map[string]string, name string) (float64, bool) {
+	v, found := rusage[name]
+	if !found {
+		return 0, false
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		log.Printf("Invalid %s resource usage value: %s", name, v)
+		return 0, false
+	}
+	return f, true
+}
+
+// rusageTime converts a timestamp from the resource usage; depending on the grid engine
+// the timestamps are in seconds or in milliseconds since the epoch
+func rusageTime(rusage map[string]string, name string) (time.Time, bool) {
+	v, ok := rusageValue(rusage, name)
+	if !ok || v <= 0 {
+		return time.Time{}, false
+	}
+	if v > 1e11 {
+		return time.Unix(0, int64(v*float64(time.Millisecond))), true
+	}
+	return time.Unix(0, int64(v*float64(time.Second))), true
+}
+
+// ControlJob DRMAASession method
+func (d1s *DRMAAV1Session) ControlJob(j *JobInfo, action process.JobAction) error {
+	switch action {
+	case process.Terminate:
+		return d1s.js.TerminateJob(j.ID)
+	case process.Suspend:
+		return d1s.js.SuspendJob(j.ID)
+	case process.Resume:
+		return d1s.js.ResumeJob(j.ID)
+	case process.Hold:
+		return d1s.js.HoldJob(j.ID)
+	case process.Release:
+		return d1s.js.ReleaseJob(j.ID)
+	}
+	return &process.UnsupportedActionError{Name: j.ID, Action: action}
+}
+
 // convertPsToDRMAAState converts DRMAA v1 state to JobState
 func convertPsToDRMAAState(ds drmaa.PsType) JobState {
 	switch ds {
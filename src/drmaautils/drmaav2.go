@@ -1,9 +1,13 @@
+//go:build !nodrmaa
+// +build !nodrmaa
+
 package drmaautils
 
 import (
 	"fmt"
 	"github.com/dgruber/drmaa2"
 	"log"
+	"process"
 )
 
 // DRMAAV2Proxy - drmaa2 proxy
@@ -23,6 +27,16 @@ func NewDRMAAV2Proxy() DRMAAProxy {
 	}
 }
 
+func init() {
+	process.RegisterProcessor("drmaa2", func(params process.ProcessorParams) (process.Processor, error) {
+		return NewGridProcessor(params.SessionName, params.AccountID, NewDRMAAV2Proxy(), params.Resources)
+	})
+}
+
+func (d2p *DRMAAV2Proxy) processorType() string {
+	return "drmaa2"
+}
+
 // CreateSession - DRMAAProxy implementation method
 // For DRMAA2 it tries to open session with the given name.
 // If such session does not exist it creates a new one.
@@ -39,6 +53,11 @@ func (d2p *DRMAAV2Proxy) CreateSession(name string) (DRMAASession, error) {
 	return &DRMAAV2Session{js}, nil
 }
 
+// DestroySession DRMAASessionDestroyer method
+func (d2p *DRMAAV2Proxy) DestroySession(name string) error {
+	return d2p.sm.DestroyJobSession(name)
+}
+
 // RunJob DRMAASession method
 func (d2s *DRMAAV2Session) RunJob(jt JobTemplate) (*JobInfo, error) {
 	d2jt := convertToV2Template(jt)
@@ -50,6 +69,26 @@ func (d2s *DRMAAV2Session) RunJob(jt JobTemplate) (*JobInfo, error) {
 	return &JobInfo{ID: job.GetId()}, nil
 }
 
+// RunBulkJobs DRMAABulkSession method
+func (d2s *DRMAAV2Session) RunBulkJobs(jt JobTemplate, begin, end, step int) ([]*JobInfo, error) {
+	// the grid decides how many tasks run at the same time
+	const noMaxParallel = -1
+	aj, err := d2s.js.RunBulkJobs(convertToV2Template(jt), begin, end, step, noMaxParallel)
+	if err != nil {
+		return nil, err
+	}
+	jobs := aj.GetJobs()
+	tasks := make([]*JobInfo, len(jobs))
+	for i := range jobs {
+		tasks[i] = &JobInfo{ID: jobs[i].GetId()}
+	}
+	return tasks, nil
+}
+
+// stagesFiles fileStagingSession method. DRMAA2 transfers the template's StageInFiles and StageOutFiles itself.
+func (d2s *DRMAAV2Session) stagesFiles() {
+}
+
 // Close DRMAASession method
 func (d2s *DRMAAV2Session) Close() error {
 	return d2s.js.Close()
@@ -64,15 +103,103 @@ func (d2s *DRMAAV2Session) UpdateJobInfo(j *JobInfo) (err error) {
 		return err
 	}
 	if len(jobs) == 0 {
-		j.State = Undetermined
-		return fmt.Errorf("No job %s found", j.ID)
+		return jobNotFoundError(j)
+	}
+	return updateFromV2Job(j, &jobs[0])
+}
+
+// maxJobsQueriedByID the maximum number of jobs that are queried one by one; more jobs
+// are retrieved with a single request for all the jobs of the session
+const maxJobsQueriedByID = 8
+
+// UpdateJobsInfo DRMAABatchSession method. A few jobs are queried by their ID, otherwise all the jobs of the session
+// are retrieved with a single request and only the given jobs are read from the result, since the persistent
+// session also lists the jobs of earlier runs.
+func (d2s *DRMAAV2Session) UpdateJobsInfo(jobs []*JobInfo) []error {
+	errs := make([]error, len(jobs))
+	if len(jobs) <= maxJobsQueriedByID {
+		for i, j := range jobs {
+			errs[i] = d2s.UpdateJobInfo(j)
+		}
+		return errs
+	}
+	sessionJobs, err := d2s.js.GetJobs(nil)
+	if err != nil {
+		for i := range jobs {
+			errs[i] = err
+		}
+		return errs
+	}
+	watched := make(map[string]bool, len(jobs))
+	for _, j := range jobs {
+		watched[j.ID] = true
+	}
+	sessionJobsByID := make(map[string]*drmaa2.Job, len(jobs))
+	for i := range sessionJobs {
+		if id := sessionJobs[i].GetId(); watched[id] {
+			sessionJobsByID[id] = &sessionJobs[i]
+		}
+	}
+	for i, j := range jobs {
+		if sj, found := sessionJobsByID[j.ID]; found {
+			errs[i] = updateFromV2Job(j, sj)
+		} else {
+			errs[i] = jobNotFoundError(j)
+		}
+	}
+	return errs
+}
+
+// updateFromV2Job copies the state and the resource usage of the drmaa2 job
+func updateFromV2Job(j *JobInfo, job *drmaa2.Job) error {
+	jobInfo, err := job.GetJobInfo()
+	if err != nil {
+		return err
 	}
-	jobInfo, _ := jobs[0].GetJobInfo()
-	// for now only map the state
 	j.State = (JobState)(jobInfo.State)
+	j.SubState = jobInfo.SubState
+	j.ExitStatus = jobInfo.ExitStatus
+	j.TerminatingSignal = jobInfo.TerminatingSignal
+	j.Annotation = jobInfo.Annotation
+	j.AllocatedMachines = jobInfo.AllocatedMachines
+	j.SubmissionMachine = jobInfo.SubmissionMachine
+	j.JobOwner = jobInfo.JobOwner
+	j.Slots = jobInfo.Slots
+	j.QueueName = jobInfo.QueueName
+	j.WallclockTime = jobInfo.WallclockTime
+	j.CPUTime = jobInfo.CPUTime
+	j.SubmissionTime = jobInfo.SubmissionTime
+	j.DispatchTime = jobInfo.DispatchTime
+	j.FinishTime = jobInfo.FinishTime
 	return nil
 }
 
+// ControlJob DRMAASession method
+func (d2s *DRMAAV2Session) ControlJob(j *JobInfo, action process.JobAction) (err error) {
+	filter := drmaa2.CreateJobInfo()
+	filter.Id = j.ID
+	var jobs []drmaa2.Job
+	if jobs, err = d2s.js.GetJobs(&filter); err != nil {
+		return err
+	}
+	if len(jobs) == 0 {
+		return fmt.Errorf("No job %s found", j.ID)
+	}
+	switch action {
+	case process.Terminate:
+		return jobs[0].Terminate()
+	case process.Suspend:
+		return jobs[0].Suspend()
+	case process.Resume:
+		return jobs[0].Resume()
+	case process.Hold:
+		return jobs[0].Hold()
+	case process.Release:
+		return jobs[0].Release()
+	}
+	return &process.UnsupportedActionError{Name: j.ID, Action: action}
+}
+
 func convertToV2Template(jt JobTemplate) (v2jt drmaa2.JobTemplate) {
 	v2jt.RemoteCommand = jt.RemoteCommand
 	v2jt.Args = make([]string, len(jt.Args), len(jt.Args))
@@ -107,13 +234,6 @@ func convertToV2Template(jt JobTemplate) (v2jt drmaa2.JobTemplate) {
 	v2jt.StageOutFiles = copyMap(jt.StageOutFiles)
 	v2jt.ResourceLimits = copyMap(jt.ResourceLimits)
 	v2jt.AccountingId = jt.AccountingID
+	v2jt.ExtensionList = copyMap(jt.ExtensionList)
 	return v2jt
 }
-
-func copyMap(in map[string]string) map[string]string {
-	out := make(map[string]string, len(in))
-	for k, v := range in {
-		out[k] = v
-	}
-	return out
-}
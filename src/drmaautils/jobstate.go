@@ -0,0 +1,171 @@
+package drmaautils
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	jobSubmitted = "submitted"
+	jobFinished  = "finished"
+)
+
+// jobStateEntry a record of the session's job state file
+type jobStateEntry struct {
+	Name        string    `json:"name"`
+	ID          string    `json:"id"`
+	Fingerprint string    `json:"fingerprint,omitempty"`
+	Event       string    `json:"event"`
+	Time        time.Time `json:"time"`
+}
+
+// sessionJobState is an append only file that records the grid jobs submitted in a session and the jobs
+// the processor stopped tracking so that a restarted driver can reattach to the jobs that are still in flight.
+// A nil sessionJobState does not record anything.
+type sessionJobState struct {
+	mu       sync.Mutex
+	f        *os.File
+	inFlight map[string]jobStateEntry // submitted jobs that did not finish indexed by job name
+}
+
+// openSessionJobState opens the <sessionName>-jobs.state file from the state directory
+// and loads the jobs that were still in flight when the previous driver stopped.
+func openSessionJobState(stateDir, sessionName string) (*sessionJobState, error) {
+	if err := os.MkdirAll(stateDir, 0775); err != nil {
+		return nil, fmt.Errorf("Error creating the job state directory %s: %v", stateDir, err)
+	}
+	stateFile := sessionJobStateFile(stateDir, sessionName)
+	s := &sessionJobState{
+		inFlight: make(map[string]jobStateEntry),
+	}
+	if err := s.load(stateFile); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(stateFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0664)
+	if err != nil {
+		return nil, fmt.Errorf("Error opening the job state %s: %v", stateFile, err)
+	}
+	s.f = f
+	log.Printf("Opened job state %s - %d jobs in flight", stateFile, len(s.inFlight))
+	return s, nil
+}
+
+// removeSessionJobState removes the job state file of the session
+func removeSessionJobState(stateDir, sessionName string) error {
+	stateFile := sessionJobStateFile(stateDir, sessionName)
+	if err := os.Remove(stateFile); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("Error removing the job state %s: %v", stateFile, err)
+	}
+	return nil
+}
+
+func sessionJobStateFile(stateDir, sessionName string) string {
+	return filepath.Join(stateDir, sessionName+"-jobs.state")
+}
+
+func (s *sessionJobState) load(stateFile string) error {
+	f, err := os.Open(stateFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("Error reading the job state %s: %v", stateFile, err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry jobStateEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			// most likely the last record was only partially written when the previous driver died
+			log.Printf("Ignore invalid job state record in %s: %v", stateFile, err)
+			continue
+		}
+		switch entry.Event {
+		case jobSubmitted:
+			s.inFlight[entry.Name] = entry
+		case jobFinished:
+			if s.inFlight[entry.Name].ID == entry.ID {
+				delete(s.inFlight, entry.Name)
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+// lookup returns the ID of the job that was submitted with the same fingerprint and did not finish
+func (s *sessionJobState) lookup(name, fingerprint string) (string, bool) {
+	if s == nil {
+		return "", false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, found := s.inFlight[name]
+	if !found || entry.Fingerprint != fingerprint {
+		return "", false
+	}
+	return entry.ID, true
+}
+
+// submitted records that the job was submitted to the grid
+func (s *sessionJobState) submitted(name, id, fingerprint string) {
+	if s == nil {
+		return
+	}
+	entry := jobStateEntry{Name: name, ID: id, Fingerprint: fingerprint, Event: jobSubmitted}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.inFlight[name] = entry
+	s.write(entry)
+}
+
+// finished records that the processor no longer tracks the job
+func (s *sessionJobState) finished(name, id string) {
+	if s == nil {
+		return
+	}
+	entry := jobStateEntry{Name: name, ID: id, Event: jobFinished}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.inFlight[name].ID == id {
+		delete(s.inFlight, name)
+	}
+	s.write(entry)
+}
+
+func (s *sessionJobState) write(entry jobStateEntry) {
+	entry.Time = time.Now()
+	line, err := json.Marshal(entry)
+	if err != nil {
+		log.Printf("Error encoding the job state entry for %s: %v", entry.Name, err)
+		return
+	}
+	if _, err = s.f.Write(append(line, '\n')); err != nil {
+		log.Printf("Error writing the job state entry for %s: %v", entry.Name, err)
+	}
+}
+
+func (s *sessionJobState) close() error {
+	if s == nil {
+		return nil
+	}
+	return s.f.Close()
+}
+
+// templateFingerprint computes a fingerprint of the command submitted with the job template
+func templateFingerprint(jt JobTemplate) string {
+	h := sha1.New()
+	fmt.Fprintln(h, jt.WorkingDirectory)
+	fmt.Fprintln(h, jt.RemoteCommand)
+	for _, a := range jt.Args {
+		fmt.Fprintln(h, a)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,77 @@
+package drmaautils
+
+import (
+	"bytes"
+	"strconv"
+)
+
+// The UGE submit options that correspond to the job template's settings. They are passed
+// as the DRMAA v1 native specification and as the qsub options of the command line proxy.
+
+func appendAccountID(buf *bytes.Buffer, jt JobTemplate) {
+	if jt.AccountingID == "" {
+		return
+	}
+	buf.WriteString("-A ")
+	buf.WriteString(jt.AccountingID)
+	buf.WriteString(" ")
+}
+
+func appendPE(buf *bytes.Buffer, jt JobTemplate) {
+	pe := jt.GetExtension("uge_jt_pe")
+	if pe == "" {
+		return
+	}
+	if jt.MinSlots == 0 && jt.MaxSlots == 0 {
+		return
+	}
+	buf.WriteString("-pe ")
+	buf.WriteString(pe)
+	buf.WriteString(" ")
+	if jt.MinSlots > 0 {
+		buf.WriteString(strconv.FormatInt(jt.MinSlots, 10))
+	}
+	if jt.MaxSlots > 0 {
+		buf.WriteString("-")
+		buf.WriteString(strconv.FormatInt(jt.MaxSlots, 10))
+	}
+	buf.WriteString(" ")
+	return
+}
+
+func appendQueue(buf *bytes.Buffer, jt JobTemplate) {
+	if jt.QueueName == "" {
+		return
+	}
+	buf.WriteString("-q ")
+	buf.WriteString(jt.QueueName)
+	buf.WriteString(" ")
+}
+
+func appendResourceLimits(buf *bytes.Buffer, jt JobTemplate) {
+	jobResourceLimits := jt.ResourceLimits
+	if len(jobResourceLimits) == 0 {
+		return
+	}
+	buf.WriteString("-l ")
+	lIndex := 0
+	for k, v := range jobResourceLimits {
+		if lIndex > 0 {
+			buf.WriteString(",")
+		}
+		buf.WriteString(k)
+		buf.WriteString("=")
+		buf.WriteString(v)
+		lIndex++
+	}
+	buf.WriteString(" ")
+}
+
+func appendNativeSpec(buf *bytes.Buffer, jt JobTemplate) {
+	nativeSpec := jt.GetExtension("uge_jt_native")
+	if nativeSpec == "" {
+		return
+	}
+	buf.WriteString(nativeSpec)
+	buf.WriteString(" ")
+}
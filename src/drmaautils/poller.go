@@ -0,0 +1,145 @@
+package drmaautils
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// jobUpdate the state of a job reported by the session poller
+type jobUpdate struct {
+	info JobInfo
+	err  error
+}
+
+// jobWatch a job whose state is being polled
+type jobWatch struct {
+	info    JobInfo
+	updates chan jobUpdate
+}
+
+// notify sends the latest update replacing the previous one if it was not received yet
+func (w *jobWatch) notify(u jobUpdate) {
+	for {
+		select {
+		case w.updates <- u:
+			return
+		default:
+		}
+		select {
+		case <-w.updates:
+		default:
+		}
+	}
+}
+
+// sessionPoller queries the state of all the watched jobs of a session at once, so that the number
+// of requests sent to the grid does not grow with the number of jobs that are waited on.
+type sessionPoller struct {
+	js       DRMAASession
+	interval time.Duration
+	mu       sync.Mutex
+	running  bool
+	watches  map[*jobWatch]struct{}
+}
+
+func newSessionPoller(js DRMAASession, interval time.Duration) *sessionPoller {
+	return &sessionPoller{
+		js:       js,
+		interval: interval,
+		watches:  make(map[*jobWatch]struct{}),
+	}
+}
+
+// watch starts polling the job's state. The returned channel receives the job's info after every poll.
+// The returned function stops watching the job.
+func (sp *sessionPoller) watch(ji *JobInfo) (<-chan jobUpdate, func()) {
+	w := &jobWatch{
+		info:    *ji,
+		updates: make(chan jobUpdate, 1),
+	}
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	sp.watches[w] = struct{}{}
+	if !sp.running {
+		sp.running = true
+		go sp.run()
+	}
+	return w.updates, func() {
+		sp.mu.Lock()
+		defer sp.mu.Unlock()
+		delete(sp.watches, w)
+	}
+}
+
+// run polls the watched jobs, starting right away, until there are no jobs left
+func (sp *sessionPoller) run() {
+	for first := true; ; first = false {
+		if !first {
+			time.Sleep(sp.interval)
+		}
+		sp.mu.Lock()
+		if len(sp.watches) == 0 {
+			sp.running = false
+			sp.mu.Unlock()
+			return
+		}
+		watches := make([]*jobWatch, 0, len(sp.watches))
+		for w := range sp.watches {
+			watches = append(watches, w)
+		}
+		sp.mu.Unlock()
+		sp.poll(watches)
+	}
+}
+
+func (sp *sessionPoller) poll(watches []*jobWatch) {
+	jobs := make([]*JobInfo, len(watches))
+	for i, w := range watches {
+		jobs[i] = &w.info
+	}
+	errs := updateJobsInfo(sp.js, jobs)
+	for i, w := range watches {
+		if errs[i] == nil {
+			fillJobTimes(&w.info)
+		}
+		w.notify(jobUpdate{info: w.info, err: errs[i]})
+	}
+}
+
+// updateJobsInfo updates the jobs with a single request if the session supports it or one job at a time otherwise
+func updateJobsInfo(js DRMAASession, jobs []*JobInfo) []error {
+	if bs, ok := js.(DRMAABatchSession); ok {
+		return bs.UpdateJobsInfo(jobs)
+	}
+	errs := make([]error, len(jobs))
+	for i, j := range jobs {
+		errs[i] = js.UpdateJobInfo(j)
+	}
+	return errs
+}
+
+// fillJobTimes uses the time when a state change was noticed if the session
+// does not report when the job started or finished
+func fillJobTimes(ji *JobInfo) {
+	now := time.Now()
+	switch ji.State {
+	case Running:
+		if ji.DispatchTime.IsZero() {
+			ji.DispatchTime = now
+		}
+	case Done, Failed:
+		if ji.FinishTime.IsZero() {
+			ji.FinishTime = now
+		}
+		if ji.WallclockTime == 0 && !ji.DispatchTime.IsZero() {
+			ji.WallclockTime = ji.FinishTime.Sub(ji.DispatchTime)
+		}
+	}
+}
+
+// jobNotFoundError is returned for a job that is not known to the session
+func jobNotFoundError(ji *JobInfo) error {
+	ji.State = Undetermined
+	return fmt.Errorf("No job %s found", ji.ID)
+}
@@ -0,0 +1,466 @@
+package drmaautils
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"config"
+	"process"
+)
+
+// simulatedJobID the last job ID assigned by any simulated session
+var simulatedJobID int64
+
+func init() {
+	process.RegisterProcessor("simulated", func(params process.ProcessorParams) (process.Processor, error) {
+		return NewGridProcessor(params.SessionName, params.AccountID, NewSimulatedProxy(params.Resources), params.Resources)
+	})
+}
+
+// SimulatedProxy - DRMAA proxy that simulates a cluster on the local machine.
+// The jobs wait in the queue for QueueDelay and then they either run as local processes
+// or, in a dry run, they pretend to run for RunTime. A FailureRate fraction of the jobs
+// fails as if the node they were dispatched to went down. As on a real grid, the jobs of a named
+// session outlive the processors that use the session.
+type SimulatedProxy struct {
+	QueueDelay  time.Duration
+	RunTime     time.Duration
+	FailureRate float64
+	DryRun      bool
+	mu          sync.Mutex
+	sessions    map[string]*SimulatedSession
+}
+
+// SimulatedSession - simulated DRMAA session
+type SimulatedSession struct {
+	proxy    *SimulatedProxy
+	name     string
+	hostname string
+	mu       sync.Mutex
+	jobs     map[string]*simulatedJob
+}
+
+// simulatedJob a job submitted to a simulated session
+type simulatedJob struct {
+	mu       sync.Mutex
+	info     JobInfo
+	cmd      *exec.Cmd
+	released chan struct{}
+	// controlled is signalled every time the job is suspended or resumed
+	controlled chan struct{}
+	terminated chan struct{}
+}
+
+// NewSimulatedProxy creates a simulated DRMAA proxy configured from "simQueueDelay" and "simRunTime"
+// (in seconds), "simFailureRate" and "simDryRun"
+func NewSimulatedProxy(resources config.Config) DRMAAProxy {
+	return &SimulatedProxy{
+		QueueDelay:  time.Duration(resources.GetFloat64Property("simQueueDelay") * float64(time.Second)),
+		RunTime:     time.Duration(resources.GetFloat64Property("simRunTime") * float64(time.Second)),
+		FailureRate: resources.GetFloat64Property("simFailureRate"),
+		DryRun:      resources.GetBoolProperty("simDryRun"),
+	}
+}
+
+func (sp *SimulatedProxy) processorType() string {
+	return "simulated"
+}
+
+// CreateSession DRMAAProxy method. It returns the existing session if one with the same name was already created.
+func (sp *SimulatedProxy) CreateSession(name string) (DRMAASession, error) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	if ss, found := sp.sessions[name]; found {
+		return ss, nil
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "localhost"
+	}
+	ss := &SimulatedSession{
+		proxy:    sp,
+		name:     name,
+		hostname: hostname,
+		jobs:     make(map[string]*simulatedJob),
+	}
+	if sp.sessions == nil {
+		sp.sessions = make(map[string]*SimulatedSession)
+	}
+	sp.sessions[name] = ss
+	return ss, nil
+}
+
+// DestroySession DRMAASessionDestroyer method. The jobs of the session that have not completed are terminated.
+func (sp *SimulatedProxy) DestroySession(name string) error {
+	sp.mu.Lock()
+	ss, found := sp.sessions[name]
+	delete(sp.sessions, name)
+	sp.mu.Unlock()
+	if !found {
+		return fmt.Errorf("No simulated session %s found", name)
+	}
+	return ss.terminateJobs()
+}
+
+// terminateJobs terminates all the jobs of the session that have not completed
+func (ss *SimulatedSession) terminateJobs() error {
+	ss.mu.Lock()
+	ids := make([]string, 0, len(ss.jobs))
+	for id := range ss.jobs {
+		ids = append(ids, id)
+	}
+	ss.mu.Unlock()
+	var firstErr error
+	for _, id := range ids {
+		if err := ss.ControlJob(&JobInfo{ID: id}, process.Terminate); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// RunJob DRMAASession method
+func (ss *SimulatedSession) RunJob(jt JobTemplate) (*JobInfo, error) {
+	return ss.submit(jt, strconv.FormatInt(atomic.AddInt64(&simulatedJobID, 1), 10))
+}
+
+// RunBulkJobs DRMAABulkSession method. As with UGE every task gets its index in SGE_TASK_ID.
+func (ss *SimulatedSession) RunBulkJobs(jt JobTemplate, begin, end, step int) ([]*JobInfo, error) {
+	if step <= 0 {
+		return nil, fmt.Errorf("Invalid bulk job step %d", step)
+	}
+	id := strconv.FormatInt(atomic.AddInt64(&simulatedJobID, 1), 10)
+	var tasks []*JobInfo
+	for task := begin; task <= end; task += step {
+		tjt := jt
+		tjt.JobEnvironment = copyMap(jt.JobEnvironment)
+		tjt.JobEnvironment["SGE_TASK_ID"] = strconv.Itoa(task)
+		ji, err := ss.submit(tjt, id+"."+strconv.Itoa(task))
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, ji)
+	}
+	return tasks, nil
+}
+
+func (ss *SimulatedSession) submit(jt JobTemplate, id string) (*JobInfo, error) {
+	sj := &simulatedJob{
+		info: JobInfo{
+			ID:                id,
+			State:             Queued,
+			SubmissionMachine: ss.hostname,
+			JobOwner:          os.Getenv("USER"),
+			Slots:             jt.MinSlots,
+			QueueName:         jt.QueueName,
+			SubmissionTime:    time.Now(),
+		},
+		released:   make(chan struct{}, 1),
+		controlled: make(chan struct{}, 1),
+		terminated: make(chan struct{}),
+	}
+	if jt.SubmitAsHold {
+		sj.info.State = QueuedHeld
+	}
+	if !ss.proxy.DryRun {
+		cmd, err := ss.prepareCmd(jt, id)
+		if err != nil {
+			return nil, err
+		}
+		sj.cmd = cmd
+	}
+	ss.mu.Lock()
+	ss.jobs[id] = sj
+	ss.mu.Unlock()
+	go ss.schedule(sj)
+	return &JobInfo{ID: id}, nil
+}
+
+// prepareCmd creates the command for the job template. As on the grid the job's output goes
+// to <jobName>.o<jobID> and its error to <jobName>.e<jobID> in the output, error or working directory.
+func (ss *SimulatedSession) prepareCmd(jt JobTemplate, id string) (*exec.Cmd, error) {
+	cmd := exec.Command(jt.RemoteCommand, jt.Args...)
+	cmd.Dir = jt.WorkingDirectory
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Env = os.Environ()
+	for k, v := range jt.JobEnvironment {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+	outputDir := jt.OutputPath
+	if outputDir == "" {
+		outputDir = jt.WorkingDirectory
+	}
+	errorDir := jt.ErrorPath
+	if errorDir == "" {
+		errorDir = jt.WorkingDirectory
+	}
+	stdout, err := createSimulatedJobOutput(outputDir, jt.JobName+".o"+id)
+	if err != nil {
+		return nil, err
+	}
+	cmd.Stdout = stdout
+	if jt.JoinFiles {
+		cmd.Stderr = stdout
+		return cmd, nil
+	}
+	stderr, err := createSimulatedJobOutput(errorDir, jt.JobName+".e"+id)
+	if err != nil {
+		stdout.Close()
+		return nil, err
+	}
+	cmd.Stderr = stderr
+	return cmd, nil
+}
+
+func createSimulatedJobOutput(dir, name string) (*os.File, error) {
+	if dir == "" {
+		dir = "."
+	}
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, fmt.Errorf("Error creating output directory %s: %v", dir, err)
+	}
+	return os.Create(filepath.Join(dir, name))
+}
+
+// schedule walks the job through the queued, running and done or failed states. A held job stays queued until it is released.
+func (ss *SimulatedSession) schedule(sj *simulatedJob) {
+	defer sj.closeOutput()
+	select {
+	case <-time.After(ss.proxy.QueueDelay):
+	case <-sj.terminated:
+		return
+	}
+	sj.mu.Lock()
+	for sj.info.State == QueuedHeld {
+		sj.mu.Unlock()
+		select {
+		case <-sj.released:
+		case <-sj.terminated:
+			return
+		}
+		sj.mu.Lock()
+	}
+	if sj.info.State != Queued {
+		sj.mu.Unlock()
+		return
+	}
+	if ss.proxy.FailureRate > 0 && rand.Float64() < ss.proxy.FailureRate {
+		log.Printf("Simulated job %s failed on %s", sj.info.ID, ss.hostname)
+		sj.finishLocked(Failed, 1, "SIGKILL")
+		sj.mu.Unlock()
+		return
+	}
+	if sj.cmd != nil {
+		if err := sj.cmd.Start(); err != nil {
+			sj.mu.Unlock()
+			log.Printf("Error starting simulated job %s: %v", sj.info.ID, err)
+			sj.finish(Failed, 1, "")
+			return
+		}
+	}
+	sj.info.State = Running
+	sj.info.AllocatedMachines = []string{ss.hostname}
+	sj.info.DispatchTime = time.Now()
+	sj.mu.Unlock()
+
+	if sj.cmd == nil {
+		sj.pretendToRun(ss.proxy.RunTime)
+		return
+	}
+	err := sj.cmd.Wait()
+	if err == nil {
+		sj.finish(Done, 0, "")
+		return
+	}
+	exitStatus, signal := 1, ""
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if ws, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+			if ws.Signaled() {
+				signal = ws.Signal().String()
+			} else {
+				exitStatus = ws.ExitStatus()
+			}
+		}
+	}
+	sj.finish(Failed, exitStatus, signal)
+}
+
+// pretendToRun completes a dry run job after it has been running for runTime. The time the job is suspended does not count.
+func (sj *simulatedJob) pretendToRun(runTime time.Duration) {
+	remaining := runTime
+	for {
+		if sj.state() == Suspended {
+			select {
+			case <-sj.controlled:
+				continue
+			case <-sj.terminated:
+				return
+			}
+		}
+		started := time.Now()
+		timer := time.NewTimer(remaining)
+		select {
+		case <-timer.C:
+			sj.mu.Lock()
+			if sj.info.State == Suspended {
+				// the job was suspended just as it was about to complete so it completes when it is resumed
+				remaining = 0
+				sj.mu.Unlock()
+				continue
+			}
+			sj.finishLocked(Done, 0, "")
+			sj.mu.Unlock()
+			return
+		case <-sj.controlled:
+			timer.Stop()
+			if remaining -= time.Since(started); remaining < 0 {
+				remaining = 0
+			}
+		case <-sj.terminated:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+func (sj *simulatedJob) state() JobState {
+	sj.mu.Lock()
+	defer sj.mu.Unlock()
+	return sj.info.State
+}
+
+// finish sets the final state of the job unless it already terminated
+func (sj *simulatedJob) finish(state JobState, exitStatus int, signal string) {
+	sj.mu.Lock()
+	defer sj.mu.Unlock()
+	sj.finishLocked(state, exitStatus, signal)
+}
+
+func (sj *simulatedJob) finishLocked(state JobState, exitStatus int, signal string) {
+	if sj.info.State == Done || sj.info.State == Failed {
+		return
+	}
+	sj.info.State = state
+	sj.info.ExitStatus = exitStatus
+	sj.info.TerminatingSignal = signal
+	sj.info.FinishTime = time.Now()
+	if !sj.info.DispatchTime.IsZero() {
+		sj.info.WallclockTime = sj.info.FinishTime.Sub(sj.info.DispatchTime)
+	}
+	if sj.cmd != nil && sj.cmd.ProcessState != nil {
+		sj.info.CPUTime = int64((sj.cmd.ProcessState.UserTime() + sj.cmd.ProcessState.SystemTime()).Seconds())
+	}
+}
+
+func (sj *simulatedJob) closeOutput() {
+	if sj.cmd == nil {
+		return
+	}
+	for _, w := range []interface{}{sj.cmd.Stdout, sj.cmd.Stderr} {
+		if f, ok := w.(*os.File); ok {
+			f.Close()
+		}
+	}
+}
+
+func (ss *SimulatedSession) getJob(id string) (*simulatedJob, error) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	sj, found := ss.jobs[id]
+	if !found {
+		return nil, fmt.Errorf("No job %s found", id)
+	}
+	return sj, nil
+}
+
+// UpdateJobInfo DRMAASession method
+func (ss *SimulatedSession) UpdateJobInfo(j *JobInfo) error {
+	sj, err := ss.getJob(j.ID)
+	if err != nil {
+		j.State = Undetermined
+		return err
+	}
+	sj.mu.Lock()
+	defer sj.mu.Unlock()
+	*j = sj.info
+	j.AllocatedMachines = append([]string(nil), sj.info.AllocatedMachines...)
+	return nil
+}
+
+// ControlJob DRMAASession method
+func (ss *SimulatedSession) ControlJob(j *JobInfo, action process.JobAction) error {
+	sj, err := ss.getJob(j.ID)
+	if err != nil {
+		return err
+	}
+	sj.mu.Lock()
+	defer sj.mu.Unlock()
+	state := sj.info.State
+	if state == Done || state == Failed {
+		if action == process.Terminate {
+			return nil
+		}
+		return fmt.Errorf("Job %s already completed", j.ID)
+	}
+	switch action {
+	case process.Terminate:
+		if sj.cmd != nil && (state == Running || state == Suspended) {
+			// the job was started in its own process group so kill the group
+			if err = syscall.Kill(-sj.cmd.Process.Pid, syscall.SIGKILL); err != nil {
+				return fmt.Errorf("Error killing job %s: %v", j.ID, err)
+			}
+			return nil
+		}
+		close(sj.terminated)
+		sj.finishLocked(Failed, 1, "SIGKILL")
+	case process.Suspend, process.Resume:
+		from, to, sig := Running, Suspended, syscall.SIGSTOP
+		if action == process.Resume {
+			from, to, sig = Suspended, Running, syscall.SIGCONT
+		}
+		if state != from {
+			return fmt.Errorf("Cannot %s job %s since it is %s", action, j.ID, state)
+		}
+		if sj.cmd != nil {
+			if err = syscall.Kill(-sj.cmd.Process.Pid, sig); err != nil {
+				return fmt.Errorf("Error sending %v to job %s: %v", sig, j.ID, err)
+			}
+		}
+		sj.info.State = to
+		select {
+		case sj.controlled <- struct{}{}:
+		default:
+		}
+	case process.Hold:
+		if state != Queued {
+			return fmt.Errorf("Cannot hold job %s since it is %s", j.ID, state)
+		}
+		sj.info.State = QueuedHeld
+	case process.Release:
+		if state != QueuedHeld {
+			return fmt.Errorf("Cannot release job %s since it is %s", j.ID, state)
+		}
+		sj.info.State = Queued
+		select {
+		case sj.released <- struct{}{}:
+		default:
+		}
+	default:
+		return &process.UnsupportedActionError{Name: j.ID, Action: action}
+	}
+	return nil
+}
+
+// Close DRMAASession method. As with a real grid session the jobs that are still running are not affected.
+func (ss *SimulatedSession) Close() error {
+	return nil
+}
@@ -0,0 +1,232 @@
+package drmaautils
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"arg"
+	"config"
+	"process"
+)
+
+// shCmdlineBuilder runs the script with the values of the named string arguments as its positional parameters
+type shCmdlineBuilder struct {
+	script string
+	args   []string
+}
+
+func (clb shCmdlineBuilder) GetCmdlineArgs(a arg.Args) ([]string, error) {
+	cmdline := []string{"-c", clb.script}
+	if len(clb.args) > 0 {
+		cmdline = append(cmdline, "sh")
+	}
+	for _, name := range clb.args {
+		v, _ := a.GetStringArgValue(name)
+		cmdline = append(cmdline, v)
+	}
+	return cmdline, nil
+}
+
+func newSimulatedProcessor(t *testing.T, sp *SimulatedProxy) (*GridProcessor, string) {
+	outputDir, err := ioutil.TempDir("", "simulated")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewGridProcessor("test", "", sp, config.Config{
+		"outputDir":          outputDir,
+		"workingDir":         outputDir,
+		"jobPollingInterval": 0.01,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p, outputDir
+}
+
+func TestSimulatedProcessorRunsJobs(t *testing.T) {
+	p, outputDir := newSimulatedProcessor(t, &SimulatedProxy{QueueDelay: 20 * time.Millisecond})
+	defer os.RemoveAll(outputDir)
+	ji, err := p.Start(context.Background(), process.Job{
+		Executable:     "sh",
+		Name:           "hello",
+		CmdlineBuilder: shCmdlineBuilder{script: "echo hello"},
+	})
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if err = ji.WaitForTermination(context.Background()); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	stdout, err := ji.JobStdout()
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	defer stdout.Close()
+	output, _ := ioutil.ReadAll(stdout)
+	if strings.TrimSpace(string(output)) != "hello" {
+		t.Errorf("Expected the job output to be hello but got %q", output)
+	}
+
+	err = p.Run(context.Background(), process.Job{
+		Executable:     "sh",
+		Name:           "fail",
+		CmdlineBuilder: shCmdlineBuilder{script: "exit 3"},
+	})
+	var jobErr *JobFailedError
+	if !errors.As(err, &jobErr) {
+		t.Error("Expected the job to fail but got", err)
+	}
+}
+
+func TestSimulatedProcessorFailuresAndCancellation(t *testing.T) {
+	failing, outputDir := newSimulatedProcessor(t, &SimulatedProxy{FailureRate: 1, DryRun: true})
+	defer os.RemoveAll(outputDir)
+	if err := failing.Run(context.Background(), process.Job{Name: "failing", CmdlineBuilder: shCmdlineBuilder{}}); err == nil {
+		t.Error("Expected the job to fail")
+	}
+
+	queued, outputDir := newSimulatedProcessor(t, &SimulatedProxy{QueueDelay: time.Hour, DryRun: true})
+	defer os.RemoveAll(outputDir)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := queued.Start(ctx, process.Job{Name: "queued", CmdlineBuilder: shCmdlineBuilder{}}); err != context.DeadlineExceeded {
+		t.Error("Expected the queued job to be cancelled but got", err)
+	}
+	ss := queued.js.(*SimulatedSession)
+	ji := &JobInfo{ID: lastSimulatedJobID(ss)}
+	if err := ss.UpdateJobInfo(ji); err != nil || ji.State != Failed {
+		t.Error("Expected the cancelled job to be terminated but it is", ji.State, err)
+	}
+}
+
+func lastSimulatedJobID(ss *SimulatedSession) string {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	for id := range ss.jobs {
+		return id
+	}
+	return ""
+}
+
+func waitForSimulatedState(t *testing.T, ss *SimulatedSession, id string, state JobState) {
+	ji := &JobInfo{ID: id}
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(5 * time.Millisecond) {
+		if err := ss.UpdateJobInfo(ji); err != nil {
+			t.Fatal(err)
+		}
+		if ji.State == state {
+			return
+		}
+	}
+	t.Fatalf("Expected job %s to be %s but it is %s", id, state, ji.State)
+}
+
+func TestSimulatedDryRunSuspendAndDestroySession(t *testing.T) {
+	sp := &SimulatedProxy{RunTime: 100 * time.Millisecond, DryRun: true}
+	s, err := sp.CreateSession("suspend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ss := s.(*SimulatedSession)
+	ji, err := ss.RunJob(JobTemplate{JobName: "suspended"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForSimulatedState(t, ss, ji.ID, Running)
+	if err = ss.ControlJob(ji, process.Suspend); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2 * sp.RunTime)
+	if err = ss.UpdateJobInfo(ji); err != nil || ji.State != Suspended {
+		t.Fatal("Expected the job to stay suspended past its run time but it is", ji.State, err)
+	}
+	if err = ss.ControlJob(ji, process.Resume); err != nil {
+		t.Fatal(err)
+	}
+	waitForSimulatedState(t, ss, ji.ID, Done)
+
+	sp.RunTime = time.Hour
+	ji, err = ss.RunJob(JobTemplate{JobName: "destroyed"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForSimulatedState(t, ss, ji.ID, Running)
+	if err = sp.DestroySession("suspend"); err != nil {
+		t.Fatal(err)
+	}
+	if err = ss.UpdateJobInfo(ji); err != nil || ji.State != Failed {
+		t.Error("Expected the job of the destroyed session to be terminated but it is", ji.State, err)
+	}
+}
+
+func TestSimulatedProcessorWritesAccountingReport(t *testing.T) {
+	outputDir, err := ioutil.TempDir("", "accounting")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(outputDir)
+	// the accounting of a session is kept for the whole process so every run uses a new session
+	sessionName := filepath.Base(outputDir)
+	p, err := NewGridProcessor(sessionName, "flyTEM", &SimulatedProxy{QueueDelay: 20 * time.Millisecond}, config.Config{
+		"outputDir":          outputDir,
+		"workingDir":         outputDir,
+		"jobPollingInterval": 0.01,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = p.Run(context.Background(), process.Job{Executable: "sh", Name: "succeeded", CmdlineBuilder: shCmdlineBuilder{script: "sleep 0.1"}}); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if err = p.Run(context.Background(), process.Job{Executable: "sh", Name: "failed", CmdlineBuilder: shCmdlineBuilder{script: "exit 3"}}); err == nil {
+		t.Fatal("Expected the job to fail")
+	}
+	if err = p.CloseSession(); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+
+	reportJSON, err := ioutil.ReadFile(filepath.Join(outputDir, sessionName+"-accounting.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var records []accountingRecord
+	if err = json.Unmarshal(reportJSON, &records); err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatal("Expected the accounting of 2 jobs but got", records)
+	}
+	hostname, _ := os.Hostname()
+	for i, expected := range []struct {
+		name       string
+		state      JobState
+		exitStatus int
+	}{
+		{"succeeded", Done, 0},
+		{"failed", Failed, 3},
+	} {
+		r := records[i]
+		if r.JobName != expected.name || r.State != expected.state.String() || r.ExitStatus != expected.exitStatus || r.Account != "flyTEM" {
+			t.Errorf("Expected %s to be %v with exit status %d but got %+v", expected.name, expected.state, expected.exitStatus, r)
+		}
+		if len(r.Hosts) != 1 || r.Hosts[0] != hostname {
+			t.Errorf("Expected %s to run on %s but got %v", r.JobName, hostname, r.Hosts)
+		}
+		if r.SubmissionTime.IsZero() || r.DispatchTime.Before(r.SubmissionTime) || r.FinishTime.Before(r.DispatchTime) {
+			t.Errorf("Expected %s to be submitted, dispatched and finished in order but got %+v", r.JobName, r)
+		}
+	}
+	if records[0].WallclockSeconds <= 0 {
+		t.Errorf("Expected the job's wall clock time but got %v", records[0].WallclockSeconds)
+	}
+	if _, err = os.Stat(filepath.Join(outputDir, sessionName+"-accounting.csv")); err != nil {
+		t.Error("Expected the CSV accounting report", err)
+	}
+}
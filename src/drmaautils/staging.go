@@ -0,0 +1,157 @@
+package drmaautils
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"process"
+)
+
+// fileStagingSession is implemented by the sessions whose DRMAA implementation transfers the template's
+// StageInFiles and StageOutFiles between the submit host and the execution node
+type fileStagingSession interface {
+	stagesFiles()
+}
+
+// sessionStagesFiles checks if the session transfers the staged files itself
+func (p *GridProcessor) sessionStagesFiles() bool {
+	_, ok := p.js.(fileStagingSession)
+	return ok
+}
+
+// stagedJob returns the job whose staged arguments point to the job's directory in the node-local "scratchDir"
+// and records the staged files in the template: StageInFiles maps every input to its scratch copy and
+// StageOutFiles maps every scratch output to its destination. Jobs run in place if no "scratchDir" is set.
+func (p *GridProcessor) stagedJob(jt *JobTemplate, j process.Job) (process.Job, error) {
+	scratchDir := p.jobScratchDir(j.Name)
+	if scratchDir == "" || !j.Staging.Declared() {
+		return j, nil
+	}
+	stagedJob, plan, err := process.StageJob(j, scratchDir)
+	if err != nil {
+		return j, err
+	}
+	jt.StageInFiles = make(map[string]string)
+	for _, f := range plan.Inputs {
+		jt.StageInFiles[f.Path] = filepath.Join(scratchDir, f.ScratchName)
+	}
+	jt.StageOutFiles = make(map[string]string)
+	for _, f := range plan.Outputs {
+		jt.StageOutFiles[filepath.Join(scratchDir, f.ScratchName)] = f.Path
+	}
+	return stagedJob, nil
+}
+
+// jobScratchDir returns the job's scratch directory on the execution node. The directory does not depend
+// on the submission so that the template of a job resubmitted after a restart matches the previous one.
+func (p *GridProcessor) jobScratchDir(jobName string) string {
+	scratchRoot := p.resources.GetStringProperty("scratchDir")
+	if scratchRoot == "" {
+		return ""
+	}
+	return filepath.Join(scratchRoot, p.sessionName, jobName)
+}
+
+// stageThroughScript replaces the template's command with a generated staging script written to "taskDir"
+// or, if it is not set, to the working directory. The script copies the inputs to the scratch directory,
+// runs the command, moves the outputs back to their destinations, checking the size of every copy,
+// and removes the scratch directory. Since the script runs on the execution node and copies the files
+// from and to their shared locations, the node must still mount the shared file system that holds
+// the files and the script; the scratch directory only keeps the job's reads and writes off it.
+// Templates that do not stage any file and the templates of sessions that transfer the staged files
+// themselves, i.e., DRMAA2 sessions, are left unchanged. It returns the script's name, if any,
+// so that the script can be removed once the job completes.
+func (p *GridProcessor) stageThroughScript(jt *JobTemplate) (string, error) {
+	if len(jt.StageInFiles) == 0 && len(jt.StageOutFiles) == 0 || p.sessionStagesFiles() {
+		return "", nil
+	}
+	taskDir := p.resources.GetStringProperty("taskDir")
+	if taskDir == "" {
+		taskDir = jt.WorkingDirectory
+	}
+	stagingScript, err := writeStagingScript(taskDir, p.jobScratchDir(jt.JobName), *jt)
+	if err != nil {
+		return "", err
+	}
+	jt.RemoteCommand = "/bin/sh"
+	jt.Args = []string{stagingScript}
+	return stagingScript, nil
+}
+
+// removeStagingScript removes the staging script of a job that completed. The script is kept
+// if the job's status is unknown since the job may still have to run it.
+func removeStagingScript(stagingScript string, jobErr error) {
+	var schedulerErr *SchedulerError
+	if stagingScript == "" || errors.As(jobErr, &schedulerErr) {
+		return
+	}
+	if err := os.Remove(stagingScript); err != nil && !os.IsNotExist(err) {
+		log.Printf("Error removing the staging script %s: %v", stagingScript, err)
+	}
+}
+
+// writeStagingScript writes the staging script of the job template to taskDir
+func writeStagingScript(taskDir, scratchDir string, jt JobTemplate) (string, error) {
+	var script bytes.Buffer
+	script.WriteString("#!/bin/sh\n")
+	fmt.Fprintf(&script, "# %s - staged through %s\n", jt.JobName, scratchDir)
+	fmt.Fprintf(&script, "scratch=%s\n", process.ShellQuote(scratchDir))
+	script.WriteString("rm -rf \"$scratch\" && mkdir -p \"$scratch\" || exit 1\n")
+	script.WriteString("trap 'rm -rf \"$scratch\"' EXIT\n")
+	script.WriteString(`verify() {
+  [ "$(wc -c < "$1")" -eq "$(wc -c < "$2")" ]
+}
+stage_in() {
+  cp "$1" "$2" && verify "$1" "$2" || { echo "Error staging in $1" >&2; exit 1; }
+}
+stage_out() {
+  mkdir -p "$(dirname "$2")" && cp "$1" "$2.staging" && verify "$1" "$2.staging" && mv "$2.staging" "$2" ||
+    { rm -f "$2.staging"; echo "Error staging out $2" >&2; exit 1; }
+}
+`)
+	for _, src := range sortedKeys(jt.StageInFiles) {
+		fmt.Fprintf(&script, "stage_in %s %s\n", process.ShellQuote(src), process.ShellQuote(jt.StageInFiles[src]))
+	}
+	args := make([]string, 0, len(jt.Args)+1)
+	args = append(args, process.ShellQuote(jt.RemoteCommand))
+	for _, a := range jt.Args {
+		args = append(args, process.ShellQuote(a))
+	}
+	fmt.Fprintf(&script, "%s\n", strings.Join(args, " "))
+	script.WriteString("status=$?\n[ $status -eq 0 ] || exit $status\n")
+	for _, src := range sortedKeys(jt.StageOutFiles) {
+		fmt.Fprintf(&script, "stage_out %s %s\n", process.ShellQuote(src), process.ShellQuote(jt.StageOutFiles[src]))
+	}
+
+	if err := os.MkdirAll(taskDir, 0775); err != nil {
+		return "", fmt.Errorf("Error creating the task directory %s: %v", taskDir, err)
+	}
+	f, err := ioutil.TempFile(taskDir, jt.JobName+".*.staging.sh")
+	if err != nil {
+		return "", fmt.Errorf("Error creating the staging script for %s in %s: %v", jt.JobName, taskDir, err)
+	}
+	defer f.Close()
+	if _, err = f.Write(script.Bytes()); err != nil {
+		return "", fmt.Errorf("Error writing the staging script %s: %v", f.Name(), err)
+	}
+	if err = f.Chmod(0775); err != nil {
+		return "", fmt.Errorf("Error making the staging script %s executable: %v", f.Name(), err)
+	}
+	return f.Name(), nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,134 @@
+package metrics
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"process"
+)
+
+const namespace = "dmgwrapper"
+
+var (
+	jobsSubmitted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "jobs_submitted_total",
+		Help:      "Number of jobs submitted",
+	}, []string{"processor"})
+	jobsRunning = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "jobs_running",
+		Help:      "Number of jobs currently running",
+	}, []string{"processor"})
+	jobsSucceeded = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "jobs_succeeded_total",
+		Help:      "Number of jobs that completed successfully",
+	}, []string{"processor"})
+	jobsFailed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "jobs_failed_total",
+		Help:      "Number of jobs that failed",
+	}, []string{"processor"})
+	jobsSkipped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "jobs_skipped_total",
+		Help:      "Number of jobs that were not run since they already completed",
+	}, []string{"processor"})
+	jobQueueWait = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "job_queue_wait_seconds",
+		Help:      "Time jobs waited between submission and start",
+		Buckets:   prometheus.ExponentialBuckets(1, 4, 10),
+	}, []string{"processor"})
+	jobRunTime = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "job_run_seconds",
+		Help:      "Time jobs ran until they completed or failed",
+		Buckets:   prometheus.ExponentialBuckets(1, 4, 10),
+	}, []string{"processor"})
+	dvidProxyRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "dvid_proxy_requests_total",
+		Help:      "Number of DVID proxy requests",
+	}, []string{"proxy", "operation", "code"})
+	dvidProxyLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "dvid_proxy_request_duration_seconds",
+		Help:      "DVID proxy request latencies",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"proxy", "operation"})
+)
+
+// aggregateProcessors the processors whose jobs only group subjobs run by other processors, e.g., the parallel
+// processor. Their jobs are left out of the running jobs and of the time histograms, which otherwise would count
+// the subjobs twice and mix the time of a whole run with the time of single jobs.
+var aggregateProcessors = map[string]bool{"parallel": true}
+
+func init() {
+	prometheus.MustRegister(jobsSubmitted, jobsRunning, jobsSucceeded, jobsFailed, jobsSkipped, jobQueueWait, jobRunTime,
+		dvidProxyRequests, dvidProxyLatency)
+}
+
+// JobEventListener returns a listener that updates the job metrics from the job lifecycle events
+func JobEventListener() process.EventListener {
+	return process.EventListenerFunc(func(e process.Event) {
+		switch e.Type {
+		case process.Submitted:
+			jobsSubmitted.WithLabelValues(e.Processor).Inc()
+		case process.Running:
+			if aggregateProcessors[e.Processor] {
+				return
+			}
+			jobsRunning.WithLabelValues(e.Processor).Inc()
+			if !e.SubmitTime.IsZero() {
+				jobQueueWait.WithLabelValues(e.Processor).Observe(e.StartTime.Sub(e.SubmitTime).Seconds())
+			}
+		case process.Skipped:
+			jobsSkipped.WithLabelValues(e.Processor).Inc()
+		case process.Finished, process.Failed:
+			if e.Type == process.Finished {
+				jobsSucceeded.WithLabelValues(e.Processor).Inc()
+			} else {
+				jobsFailed.WithLabelValues(e.Processor).Inc()
+			}
+			if !e.StartTime.IsZero() && !aggregateProcessors[e.Processor] {
+				jobsRunning.WithLabelValues(e.Processor).Dec()
+				jobRunTime.WithLabelValues(e.Processor).Observe(e.Time.Sub(e.StartTime).Seconds())
+			}
+		}
+	})
+}
+
+// ObserveDVIDProxyRequest records a request handled by the named DVID proxy
+func ObserveDVIDProxyRequest(proxy, operation string, statusCode int, latency time.Duration) {
+	dvidProxyRequests.WithLabelValues(proxy, operation, strconv.Itoa(statusCode)).Inc()
+	dvidProxyLatency.WithLabelValues(proxy, operation).Observe(latency.Seconds())
+}
+
+// StartServer starts serving the metrics on the given address under /metrics
+// and the status of the jobs from the given board under /jobs
+func StartServer(address string, board *process.JobStatusBoard) error {
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		return fmt.Errorf("Error listening on %s: %v", address, err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.Handle("/jobs", jobStatusHandler{board})
+	mux.Handle("/jobs/", jobStatusHandler{board})
+	log.Printf("Serving metrics on %v", l.Addr())
+	go func() {
+		if err := http.Serve(l, mux); err != nil {
+			log.Printf("Metrics server on %v stopped: %v", l.Addr(), err)
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,100 @@
+package metrics
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"process"
+)
+
+// counterValues returns the current values of the given metrics; the metrics are registered globally
+// so the tests check how much they changed
+func counterValues(metrics ...prometheus.Collector) []float64 {
+	values := make([]float64, len(metrics))
+	for i, m := range metrics {
+		values[i] = testutil.ToFloat64(m)
+	}
+	return values
+}
+
+func TestJobEventListener(t *testing.T) {
+	jobMetrics := []prometheus.Collector{
+		jobsSubmitted.WithLabelValues("local"),
+		jobsRunning.WithLabelValues("local"),
+		jobsSucceeded.WithLabelValues("local"),
+		jobsFailed.WithLabelValues("local"),
+		jobsSkipped.WithLabelValues("local"),
+		jobsSubmitted.WithLabelValues("parallel"),
+		jobsRunning.WithLabelValues("parallel"),
+		jobsSucceeded.WithLabelValues("parallel"),
+	}
+	before := counterValues(jobMetrics...)
+	listener := JobEventListener()
+	submitted := time.Now()
+	started := submitted.Add(time.Second)
+	completed := started.Add(time.Minute)
+	for _, processor := range []string{"local", "parallel"} {
+		for _, e := range []process.Event{
+			{Type: process.Submitted, Processor: processor, JobName: "succeeded", Time: submitted, SubmitTime: submitted},
+			{Type: process.Running, Processor: processor, JobName: "succeeded", Time: started, SubmitTime: submitted, StartTime: started},
+			{Type: process.Submitted, Processor: processor, JobName: "running", Time: submitted, SubmitTime: submitted},
+			{Type: process.Running, Processor: processor, JobName: "running", Time: started, SubmitTime: submitted, StartTime: started},
+			{Type: process.Finished, Processor: processor, JobName: "succeeded", Time: completed, SubmitTime: submitted, StartTime: started},
+			{Type: process.Failed, Processor: processor, JobName: "notStarted", Time: submitted},
+			{Type: process.Skipped, Processor: processor, JobName: "skipped", Time: submitted},
+		} {
+			listener.JobEvent(e)
+		}
+	}
+
+	after := counterValues(jobMetrics...)
+	for i, td := range []struct {
+		name     string
+		expected float64
+	}{
+		{"local submitted", 2},
+		{"local running", 1},
+		{"local succeeded", 1},
+		{"local failed", 1},
+		{"local skipped", 1},
+		{"parallel submitted", 2},
+		{"parallel running", 0},
+		{"parallel succeeded", 1},
+	} {
+		if change := after[i] - before[i]; change != td.expected {
+			t.Errorf("Expected %s to change by %v but it changed by %v", td.name, td.expected, change)
+		}
+	}
+	// only the local jobs are timed
+	if n := testutil.CollectAndCount(jobQueueWait); n != 1 {
+		t.Errorf("Expected the queue wait of only the local jobs but got %d series", n)
+	}
+	if n := testutil.CollectAndCount(jobRunTime); n != 1 {
+		t.Errorf("Expected the run time of only the local jobs but got %d series", n)
+	}
+}
+
+func TestObserveDVIDProxyRequest(t *testing.T) {
+	requestMetrics := []prometheus.Collector{
+		dvidProxyRequests.WithLabelValues("test", "retrieveTile", "200"),
+		dvidProxyRequests.WithLabelValues("test", "retrieveTile", "404"),
+	}
+	before := counterValues(requestMetrics...)
+	ObserveDVIDProxyRequest("test", "retrieveTile", http.StatusOK, time.Millisecond)
+	ObserveDVIDProxyRequest("test", "retrieveTile", http.StatusOK, time.Millisecond)
+	ObserveDVIDProxyRequest("test", "retrieveTile", http.StatusNotFound, time.Millisecond)
+	after := counterValues(requestMetrics...)
+	if n := after[0] - before[0]; n != 2 {
+		t.Error("Expected 2 successful requests but got", n)
+	}
+	if n := after[1] - before[1]; n != 1 {
+		t.Error("Expected 1 failed request but got", n)
+	}
+	if n := testutil.CollectAndCount(dvidProxyLatency); n != 1 {
+		t.Errorf("Expected the latencies of one proxy operation but got %d series", n)
+	}
+}
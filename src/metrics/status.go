@@ -0,0 +1,40 @@
+package metrics
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"process"
+)
+
+// jobStatusHandler serves the status of all jobs under /jobs and the status of a single job under /jobs/<name>
+type jobStatusHandler struct {
+	board *process.JobStatusBoard
+}
+
+func (h jobStatusHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "Only GET requests are supported", http.StatusMethodNotAllowed)
+		return
+	}
+	var status interface{}
+	if name := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/jobs"), "/"); name != "" {
+		js, found := h.board.JobStatus(name)
+		if !found {
+			http.Error(w, "Job "+name+" not found", http.StatusNotFound)
+			return
+		}
+		status = js
+	} else {
+		status = h.board.Report()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(status); err != nil {
+		log.Printf("Error encoding the job status: %v", err)
+	}
+}
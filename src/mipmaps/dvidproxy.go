@@ -10,10 +10,15 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"config"
+	"metrics"
 )
 
+// observeDVIDProxyRequest records a request handled by a DVID proxy
+var observeDVIDProxyRequest = metrics.ObserveDVIDProxyRequest
+
 type dvidproxy struct {
 	// name - instance name
 	name string
@@ -88,8 +93,29 @@ func (dp *dvidproxy) setupDVIDProxyRouter() {
 	dp.httpImpl = &http.Server{Handler: router}
 	dp.httpImpl.SetKeepAlivesEnabled(false)
 
-	router.GET("/api/node/:owner/:storeName/:nodeUID/:dataInstance/:orientation/:scale/:xcoord/:ycoord/:zcoord", dp.retrieveTile)
-	router.POST("/api/node/:owner/:storeName/:nodeUID/:dataInstance/:orientation/:scale/:xcoord/:ycoord/:zcoord", dp.storeTile)
+	router.GET("/api/node/:owner/:storeName/:nodeUID/:dataInstance/:orientation/:scale/:xcoord/:ycoord/:zcoord", dp.instrument("retrieveTile", dp.retrieveTile))
+	router.POST("/api/node/:owner/:storeName/:nodeUID/:dataInstance/:orientation/:scale/:xcoord/:ycoord/:zcoord", dp.instrument("storeTile", dp.storeTile))
+}
+
+// statusRecorder records the status code of the response
+type statusRecorder struct {
+	http.ResponseWriter
+	statusCode int
+}
+
+func (sr *statusRecorder) WriteHeader(statusCode int) {
+	sr.statusCode = statusCode
+	sr.ResponseWriter.WriteHeader(statusCode)
+}
+
+// instrument records the number, the latency and the status codes of the requests handled by h
+func (dp *dvidproxy) instrument(operation string, h httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		start := time.Now()
+		sr := &statusRecorder{ResponseWriter: w, statusCode: http.StatusOK}
+		h(sr, r, params)
+		observeDVIDProxyRequest(dp.name, operation, sr.statusCode, time.Since(start))
+	}
 }
 
 func (dp *dvidproxy) startDVIDProxy(l net.Listener) error {
@@ -0,0 +1,50 @@
+package mipmaps
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestDVIDProxyInstrumentRecordsRequests(t *testing.T) {
+	type observation struct {
+		proxy, operation string
+		statusCode       int
+	}
+	var observed []observation
+	defer func(observe func(string, string, int, time.Duration)) { observeDVIDProxyRequest = observe }(observeDVIDProxyRequest)
+	observeDVIDProxyRequest = func(proxy, operation string, statusCode int, latency time.Duration) {
+		observed = append(observed, observation{proxy, operation, statusCode})
+	}
+
+	dp := &dvidproxy{name: "test"}
+	found := dp.instrument("retrieveTile", func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		w.Write([]byte("tile"))
+	})
+	notFound := dp.instrument("retrieveTile", func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		http.Error(w, "no tile", http.StatusNotFound)
+	})
+	stored := dp.instrument("storeTile", func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		w.WriteHeader(http.StatusCreated)
+	})
+	for _, h := range []httprouter.Handle{found, notFound, stored} {
+		h(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/node", nil), nil)
+	}
+
+	expected := []observation{
+		{"test", "retrieveTile", http.StatusOK},
+		{"test", "retrieveTile", http.StatusNotFound},
+		{"test", "storeTile", http.StatusCreated},
+	}
+	if len(observed) != len(expected) {
+		t.Fatal("Expected", expected, "but got", observed)
+	}
+	for i := range expected {
+		if observed[i] != expected[i] {
+			t.Error("Expected", expected[i], "but got", observed[i])
+		}
+	}
+}
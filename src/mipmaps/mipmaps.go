@@ -325,6 +325,35 @@ func (clb serviceCmdlineBuilder) GetCmdlineArgs(a arg.Args) ([]string, error) {
 	return cmdargs, err
 }
 
+// ZBlockArgs splits the volume processed by a pyramid into blocks of "zLayersPerPyramidBlock" layers and returns
+// the arguments of every block so that the scale levels of a block can be generated as soon as the block
+// is retiled. Only XY pyramids are split since their layers are scaled independently of each other;
+// the arguments are returned unchanged for the other orientations or if "zLayersPerPyramidBlock" is not set.
+func ZBlockArgs(args *arg.Args, resources config.Config) ([]arg.Args, error) {
+	var mipmapsAttrs Attrs
+	if err := mipmapsAttrs.extractMipmapsAttrs(args); err != nil {
+		return nil, err
+	}
+	zBlockSize := resources.GetInt64Property("zLayersPerPyramidBlock")
+	minZ := mipmapsAttrs.processedVolume.z
+	maxZ := mipmapsAttrs.processedVolume.maxZ()
+	if zBlockSize <= 0 || mipmapsAttrs.targetOrientation != XY || maxZ-minZ <= zBlockSize {
+		return []arg.Args{args.Clone()}, nil
+	}
+	var blocks []arg.Args
+	for z := minZ; z < maxZ; z += zBlockSize {
+		blockMaxZ := z + zBlockSize
+		if blockMaxZ > maxZ {
+			blockMaxZ = maxZ
+		}
+		blockArgs := args.Clone()
+		blockArgs.UpdateInt64Arg("target_min_z", z)
+		blockArgs.UpdateInt64Arg("target_max_z", blockMaxZ)
+		blocks = append(blocks, blockArgs)
+	}
+	return blocks, nil
+}
+
 // retileJobSplitter splitter for retile jobs
 type retileJobSplitter struct {
 	resources    config.Config
@@ -409,6 +438,7 @@ func (s retileJobSplitter) SplitJob(j process.Job, jch chan<- process.Job) error
 					Name:           fmt.Sprintf("%s_%d", j.Name, s.nextJobIndex),
 					JArgs:          newJobArgs,
 					CmdlineBuilder: cmdlineBuilder,
+					Requirements:   process.ResourceRequirements{Role: "retile"},
 				}
 				log.Printf("Generate Retiling Job %v", newJob)
 				jch <- newJob
@@ -485,6 +515,7 @@ func (s scaleJobSplitter) SplitJob(j process.Job, jch chan<- process.Job) error
 			Name:           fmt.Sprintf("%s_%d", j.Name, s.nextJobIndex),
 			JArgs:          newJobArgs,
 			CmdlineBuilder: cmdlineBuilder,
+			Requirements:   process.ResourceRequirements{Role: "scale"},
 		}
 		log.Printf("Generate Scaling Job: %v", newJob)
 		jch <- newJob
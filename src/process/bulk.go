@@ -0,0 +1,111 @@
+package process
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// BulkProcessor is implemented by the processors that can submit many jobs at once, e.g., as a grid array job
+type BulkProcessor interface {
+	// StartBulk submits all jobs together under the given name and returns the info of every job in the same order
+	StartBulk(ctx context.Context, name string, jobs []Job) ([]Info, error)
+}
+
+// decoratedProcessor is implemented by the decorators to expose the processor they decorate
+type decoratedProcessor interface {
+	decorated() Processor
+}
+
+// asBulkProcessor returns the processor as a BulkProcessor if both the processor
+// and the processor it decorates, if it is a decorator, support bulk submission
+func asBulkProcessor(p Processor) (BulkProcessor, bool) {
+	bp, ok := p.(BulkProcessor)
+	if !ok {
+		return nil, false
+	}
+	if dp, ok := p.(decoratedProcessor); ok {
+		if _, ok = asBulkProcessor(dp.decorated()); !ok {
+			return nil, false
+		}
+	}
+	return bp, true
+}
+
+// bulkProcessor returns the subjob processor if it supports bulk submission and "bulkJobSize" is greater than one
+func (p *parallelProcessor) bulkProcessor() (BulkProcessor, int) {
+	bulkJobSize := p.resources.GetIntProperty("bulkJobSize")
+	if bulkJobSize <= 1 {
+		return nil, 0
+	}
+	bp, ok := asBulkProcessor(p.jobProcessor)
+	if !ok {
+		return nil, 0
+	}
+	return bp, bulkJobSize
+}
+
+// processBulk submits the subjobs in batches of at most bulkJobSize jobs and waits for all of them to complete
+func (p *parallelProcessor) processBulk(ctx context.Context, j Job, bp BulkProcessor, bulkJobSize int,
+	jch <-chan Job, journal *jobJournal, summary *runSummary) {
+	var wg sync.WaitGroup
+	var batch []Job
+	var fingerprints []string
+	nBatches := 0
+
+	submit := func() {
+		if len(batch) == 0 {
+			return
+		}
+		nBatches++
+		jobs, jobFingerprints := batch, fingerprints
+		batch, fingerprints = nil, nil
+		started := time.Now()
+		infos, err := bp.StartBulk(ctx, fmt.Sprintf("%s_%d", j.Name, nBatches), jobs)
+		if err != nil {
+			log.Printf("Error submitting %d jobs of %s: %v", len(jobs), j.Name, err)
+		}
+		for i, sj := range jobs {
+			if err != nil {
+				recordBulkJob(sj, jobFingerprints[i], err, started, journal, summary)
+				continue
+			}
+			wg.Add(1)
+			go func(sj Job, fingerprint string, ji Info) {
+				defer wg.Done()
+				recordBulkJob(sj, fingerprint, ji.WaitForTermination(ctx), started, journal, summary)
+			}(sj, jobFingerprints[i], infos[i])
+		}
+	}
+
+	for sj := range jch {
+		if ctx.Err() != nil {
+			break
+		}
+		fingerprint, completed := isCompletedJob(sj, journal, summary)
+		if completed {
+			continue
+		}
+		batch = append(batch, sj)
+		fingerprints = append(fingerprints, fingerprint)
+		if len(batch) >= bulkJobSize {
+			submit()
+		}
+	}
+	if ctx.Err() == nil {
+		submit()
+	}
+	wg.Wait()
+}
+
+func recordBulkJob(sj Job, fingerprint string, err error, started time.Time, journal *jobJournal, summary *runSummary) {
+	if err != nil {
+		log.Printf("Job %s failed: %v", sj.Name, err)
+	}
+	summary.completed(sj, err, started)
+	if journal != nil {
+		journal.record(sj, fingerprint, err)
+	}
+}
@@ -0,0 +1,258 @@
+package process
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"config"
+)
+
+// cacheEntry the content of a cache entry
+type cacheEntry struct {
+	Name string    `json:"name"`
+	Time time.Time `json:"time"`
+}
+
+// cachingProcessor skips the jobs that already completed successfully with the same inputs
+type cachingProcessor struct {
+	JobWatcher
+	jobProcessor Processor
+	cacheDir     string
+	hashInputs   bool
+}
+
+// CacheProcessing - decorator that skips a job if a job with the same executable, command line arguments
+// and input files already completed successfully. The fingerprints of the successful jobs are kept in "jobCacheDir";
+// if it is not set the jobs are not cached. The input files are compared by size and modification time or,
+// if "jobCacheHashInputs" is set, by their content. Only the jobs that declare or list their input files are cached,
+// since for any other job there is no way to tell whether its inputs changed.
+func CacheProcessing(resources config.Config) ProcessorDecorator {
+	return func(p Processor) Processor {
+		cacheDir := resources.GetStringProperty("jobCacheDir")
+		if cacheDir == "" {
+			return p
+		}
+		return &cachingProcessor{
+			jobProcessor: p,
+			cacheDir:     cacheDir,
+			hashInputs:   resources.GetBoolProperty("jobCacheHashInputs"),
+		}
+	}
+}
+
+// Run the job unless it is cached
+func (p *cachingProcessor) Run(ctx context.Context, j Job) error {
+	fingerprint := p.lookup(j)
+	if fingerprint == "" {
+		return p.jobProcessor.Run(ctx, j)
+	}
+	if p.isCached(fingerprint) {
+		skipJob(ctx, j)
+		return nil
+	}
+	if err := p.jobProcessor.Run(ctx, j); err != nil {
+		return err
+	}
+	p.store(j, fingerprint)
+	return nil
+}
+
+// Start the job unless it is cached; the job is added to the cache when it completes successfully
+func (p *cachingProcessor) Start(ctx context.Context, j Job) (Info, error) {
+	fingerprint := p.lookup(j)
+	if fingerprint == "" {
+		return p.jobProcessor.Start(ctx, j)
+	}
+	if p.isCached(fingerprint) {
+		skipJob(ctx, j)
+		return skippedJobInfo{name: j.Name}, nil
+	}
+	ji, err := p.jobProcessor.Start(ctx, j)
+	if err != nil {
+		return ji, err
+	}
+	return cachedJobInfo{Info: ji, onSuccess: func() { p.store(j, fingerprint) }}, nil
+}
+
+// StartBulk submits the jobs that are not cached with the decorated processor
+func (p *cachingProcessor) StartBulk(ctx context.Context, name string, jobs []Job) ([]Info, error) {
+	bp, ok := asBulkProcessor(p.jobProcessor)
+	if !ok {
+		return nil, fmt.Errorf("Jobs of %s cannot be submitted in bulk", name)
+	}
+	infos := make([]Info, len(jobs))
+	fingerprints := make([]string, len(jobs))
+	var uncached []Job
+	var uncachedIndexes []int
+	for i, j := range jobs {
+		fingerprints[i] = p.lookup(j)
+		if fingerprints[i] != "" && p.isCached(fingerprints[i]) {
+			skipJob(ctx, j)
+			infos[i] = skippedJobInfo{name: j.Name}
+			continue
+		}
+		uncached = append(uncached, j)
+		uncachedIndexes = append(uncachedIndexes, i)
+	}
+	if len(uncached) == 0 {
+		return infos, nil
+	}
+	started, err := bp.StartBulk(ctx, name, uncached)
+	if err != nil {
+		return nil, err
+	}
+	for u, ji := range started {
+		i := uncachedIndexes[u]
+		if fingerprints[i] == "" {
+			infos[i] = ji
+			continue
+		}
+		j, fingerprint := jobs[i], fingerprints[i]
+		infos[i] = cachedJobInfo{Info: ji, onSuccess: func() { p.store(j, fingerprint) }}
+	}
+	return infos, nil
+}
+
+func (p *cachingProcessor) decorated() Processor {
+	return p.jobProcessor
+}
+
+// skipJob reports the job as skipped to the event listeners and to the run summary of its parallel job
+func skipJob(ctx context.Context, j Job) {
+	log.Printf("Skip job %s - it already completed with the same inputs", j.Name)
+	NewJobTracker(ctx, "cache", j.Name).Skipped()
+	reportSkipped(ctx, j)
+}
+
+// lookup returns the job's fingerprint or an empty string if the job cannot be cached
+func (p *cachingProcessor) lookup(j Job) string {
+	inputFiles := j.InputFiles
+	if len(inputFiles) == 0 && j.ListInputFiles != nil {
+		var err error
+		if inputFiles, err = j.ListInputFiles(); err != nil {
+			log.Printf("Job %s is not cached: %v", j.Name, err)
+			return ""
+		}
+	}
+	if len(inputFiles) == 0 {
+		return ""
+	}
+	fingerprint, err := p.fingerprint(j, inputFiles)
+	if err != nil {
+		log.Printf("Job %s is not cached: %v", j.Name, err)
+		return ""
+	}
+	return fingerprint
+}
+
+// fingerprint computes the fingerprint of the job's executable, arguments and input files
+func (p *cachingProcessor) fingerprint(j Job, inputFiles []string) (string, error) {
+	cmdFingerprint, err := jobFingerprint(j)
+	if err != nil {
+		return "", err
+	}
+	h := sha1.New()
+	fmt.Fprintln(h, cmdFingerprint)
+	for _, inputFile := range inputFiles {
+		fi, err := os.Stat(inputFile)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintln(h, inputFile, fi.Size())
+		if !p.hashInputs {
+			fmt.Fprintln(h, fi.ModTime().UnixNano())
+			continue
+		}
+		if err = hashFile(h, inputFile); err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// hashFile writes the content of the named file to w
+func hashFile(w io.Writer, name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
+
+func (p *cachingProcessor) entryName(fingerprint string) string {
+	return filepath.Join(p.cacheDir, fingerprint[:2], fingerprint)
+}
+
+// isCached checks if a job with the given fingerprint already completed successfully
+func (p *cachingProcessor) isCached(fingerprint string) bool {
+	_, err := os.Stat(p.entryName(fingerprint))
+	return err == nil
+}
+
+// store records that the job completed successfully
+func (p *cachingProcessor) store(j Job, fingerprint string) {
+	entryName := p.entryName(fingerprint)
+	if err := os.MkdirAll(filepath.Dir(entryName), 0775); err != nil {
+		log.Printf("Error creating the cache directory for %s: %v", j.Name, err)
+		return
+	}
+	entry, err := json.Marshal(cacheEntry{Name: j.Name, Time: time.Now()})
+	if err != nil {
+		log.Printf("Error encoding the cache entry for %s: %v", j.Name, err)
+		return
+	}
+	if err = ioutil.WriteFile(entryName, entry, 0664); err != nil {
+		log.Printf("Error writing the cache entry for %s: %v", j.Name, err)
+	}
+}
+
+// cachedJobInfo calls onSuccess when the job completes successfully
+type cachedJobInfo struct {
+	Info
+	onSuccess func()
+}
+
+// WaitForTermination waits for the job's completion
+func (cji cachedJobInfo) WaitForTermination(ctx context.Context) error {
+	if err := cji.Info.WaitForTermination(ctx); err != nil {
+		return err
+	}
+	cji.onSuccess()
+	return nil
+}
+
+// skippedJobInfo the info of a job that was skipped because it was found in the cache
+type skippedJobInfo struct {
+	name string
+}
+
+// JobStdout returns an error since the job did not run
+func (sji skippedJobInfo) JobStdout() (io.ReadCloser, error) {
+	return nil, fmt.Errorf("Job %s was skipped since it already completed with the same inputs", sji.name)
+}
+
+// JobStderr returns an error since the job did not run
+func (sji skippedJobInfo) JobStderr() (io.ReadCloser, error) {
+	return nil, fmt.Errorf("Job %s was skipped since it already completed with the same inputs", sji.name)
+}
+
+// WaitForTermination returns immediately
+func (sji skippedJobInfo) WaitForTermination(ctx context.Context) error {
+	return nil
+}
+
+// Control does nothing since the job already completed
+func (sji skippedJobInfo) Control(action JobAction) error {
+	return nil
+}
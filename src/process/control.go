@@ -0,0 +1,55 @@
+package process
+
+import (
+	"fmt"
+)
+
+// JobAction an operation that changes the state of a started job
+type JobAction int
+
+const (
+	// Terminate stops the job
+	Terminate JobAction = iota
+	// Suspend pauses a running job
+	Suspend
+	// Resume continues a suspended job
+	Resume
+	// Hold prevents a queued job from being scheduled
+	Hold
+	// Release allows a held job to be scheduled
+	Release
+)
+
+// String representation of a JobAction
+func (a JobAction) String() string {
+	switch a {
+	case Terminate:
+		return "terminate"
+	case Suspend:
+		return "suspend"
+	case Resume:
+		return "resume"
+	case Hold:
+		return "hold"
+	case Release:
+		return "release"
+	}
+	return fmt.Sprintf("JobAction(%d)", int(a))
+}
+
+// UnsupportedActionError is returned when an action cannot be applied to a job
+type UnsupportedActionError struct {
+	Name   string
+	Action JobAction
+}
+
+// Error returns the job's name and the unsupported action
+func (e *UnsupportedActionError) Error() string {
+	return fmt.Sprintf("Job %s does not support %s", e.Name, e.Action)
+}
+
+// JobController is implemented by the processors that can apply an action
+// to all the jobs they started that have not completed yet
+type JobController interface {
+	ControlJobs(action JobAction) error
+}
@@ -0,0 +1,143 @@
+package process
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// JobError is the error of a single job
+type JobError struct {
+	Name string
+	Err  error
+}
+
+// Error returns the job's name and error
+func (e *JobError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Name, e.Err)
+}
+
+// Unwrap returns the job's underlying error
+func (e *JobError) Unwrap() error {
+	return e.Err
+}
+
+// JobErrors is the error returned by a processor that ran multiple jobs and
+// one or more of them failed. It contains an entry for every failed job.
+type JobErrors []*JobError
+
+// Error lists all failed jobs and their errors
+func (errs JobErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, e := range errs {
+		msgs[i] = e.Error()
+	}
+	return fmt.Sprintf("%d job(s) failed: %s", len(errs), strings.Join(msgs, "; "))
+}
+
+// Is reports whether any of the jobs failed with the target error, e.g., context.Canceled
+func (errs JobErrors) Is(target error) bool {
+	for _, e := range errs {
+		if errors.Is(e, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// Retriable returns true if all the failed jobs may succeed if they are resubmitted;
+// resubmitting the parallel job cannot help if any of its subjobs can never succeed
+func (errs JobErrors) Retriable() bool {
+	if len(errs) == 0 {
+		return false
+	}
+	for _, e := range errs {
+		if !IsRetriable(e) {
+			return false
+		}
+	}
+	return true
+}
+
+// InvalidArgsError is returned when a job's command line arguments cannot be built.
+// Such a job can never succeed so it is not worth resubmitting it.
+type InvalidArgsError struct {
+	Name string
+	Err  error
+}
+
+// Error returns the job's name and the arguments error
+func (e *InvalidArgsError) Error() string {
+	return fmt.Sprintf("Invalid arguments for %s: %v", e.Name, e.Err)
+}
+
+// Unwrap returns the underlying arguments error
+func (e *InvalidArgsError) Unwrap() error {
+	return e.Err
+}
+
+// Retriable always returns false
+func (e *InvalidArgsError) Retriable() bool {
+	return false
+}
+
+// TimeoutStage the stage of a job that exceeded its time limit
+type TimeoutStage int
+
+const (
+	// RunTimeout the job ran longer than its runtime limit
+	RunTimeout TimeoutStage = iota
+	// QueueTimeout the job waited in the queue longer than its queue limit, e.g.,
+	// because the queue is busy; such a job may be resubmitted to a different queue
+	QueueTimeout
+)
+
+// String representation of a TimeoutStage
+func (s TimeoutStage) String() string {
+	switch s {
+	case RunTimeout:
+		return "run"
+	case QueueTimeout:
+		return "queue"
+	}
+	return fmt.Sprintf("TimeoutStage(%d)", int(s))
+}
+
+// TimeoutError is returned when a job does not complete within its time limit
+type TimeoutError struct {
+	Name  string
+	Limit time.Duration
+	Stage TimeoutStage
+}
+
+// Error returns the job's name, the stage that timed out and the time limit
+func (e *TimeoutError) Error() string {
+	if e.Stage == QueueTimeout {
+		return fmt.Sprintf("Job %s queue timeout after %v", e.Name, e.Limit)
+	}
+	return fmt.Sprintf("Job %s timeout after %v", e.Name, e.Limit)
+}
+
+// Retriable always returns true
+func (e *TimeoutError) Retriable() bool {
+	return true
+}
+
+// IsRetriable checks if a job that failed with the given error may succeed if it is resubmitted.
+// Errors that implement a Retriable() bool method decide for themselves, e.g., timeouts and grid failures
+// are transient, cancelled jobs are never retried and any other error, such as a local command that exited
+// with an error or a missing input, is considered deterministic.
+func IsRetriable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var r interface {
+		Retriable() bool
+	}
+	if errors.As(err, &r) {
+		return r.Retriable()
+	}
+	return false
+}
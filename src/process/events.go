@@ -0,0 +1,198 @@
+package process
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// EventType the type of a job lifecycle event
+type EventType int
+
+const (
+	// Submitted - the job was handed to the processor
+	Submitted EventType = iota
+	// Queued - the job is waiting for resources
+	Queued
+	// Running - the job started running
+	Running
+	// Finished - the job completed successfully
+	Finished
+	// Failed - the job could not be started or it terminated with an error
+	Failed
+	// Skipped - the job was not run since it already completed with the same inputs
+	Skipped
+)
+
+// String representation of an EventType
+func (t EventType) String() string {
+	switch t {
+	case Submitted:
+		return "Submitted"
+	case Queued:
+		return "Queued"
+	case Running:
+		return "Running"
+	case Finished:
+		return "Finished"
+	case Failed:
+		return "Failed"
+	case Skipped:
+		return "Skipped"
+	}
+	return "Unknown"
+}
+
+// Event describes a transition in a job's lifecycle
+type Event struct {
+	Type EventType
+	// Processor the type of the processor that runs the job, e.g., local or drmaa1
+	Processor string
+	// JobName the name of the job
+	JobName string
+	// JobID the ID assigned to the job by the processor, e.g., the grid job ID or the local process ID
+	JobID string
+	// Host where the job runs; it is only known once the job started running
+	Host string
+	// Time when the event occurred
+	Time time.Time
+	// SubmitTime when the job was submitted
+	SubmitTime time.Time
+	// StartTime when the job started running; zero if the job did not start yet
+	StartTime time.Time
+	// Err the error that caused the job to fail; only set for Failed events
+	Err error
+}
+
+// EventListener receives job lifecycle events. Listeners may be called concurrently
+// from multiple jobs so they must not block and must be safe for concurrent use.
+type EventListener interface {
+	JobEvent(e Event)
+}
+
+// EventListenerFunc adapts an ordinary function to an EventListener
+type EventListenerFunc func(e Event)
+
+// JobEvent calls f(e)
+func (f EventListenerFunc) JobEvent(e Event) {
+	f(e)
+}
+
+type eventListenersKey struct{}
+
+// WithEventListener returns a context that delivers the events of all jobs processed with it
+// to the given listener in addition to the listeners already registered with the parent context.
+func WithEventListener(ctx context.Context, l EventListener) context.Context {
+	parentListeners := eventListeners(ctx)
+	listeners := make([]EventListener, len(parentListeners), len(parentListeners)+1)
+	copy(listeners, parentListeners)
+	return context.WithValue(ctx, eventListenersKey{}, append(listeners, l))
+}
+
+func eventListeners(ctx context.Context) []EventListener {
+	listeners, _ := ctx.Value(eventListenersKey{}).([]EventListener)
+	return listeners
+}
+
+// JobTracker follows a job through its lifecycle and notifies the listeners registered with
+// the context in which the job was submitted. Processors create a tracker when they start a job
+// and report the transitions as they observe them; each transition is only reported once.
+type JobTracker struct {
+	mu         sync.Mutex
+	listeners  []EventListener
+	processor  string
+	jobName    string
+	jobID      string
+	host       string
+	submitTime time.Time
+	startTime  time.Time
+	queued     bool
+	done       bool
+}
+
+// NewJobTracker creates a tracker for the named job run by the given processor type
+func NewJobTracker(ctx context.Context, processor, jobName string) *JobTracker {
+	return &JobTracker{
+		listeners: eventListeners(ctx),
+		processor: processor,
+		jobName:   jobName,
+	}
+}
+
+// Submitted reports that the job was submitted and it was assigned the given ID
+func (t *JobTracker) Submitted(jobID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.jobID = jobID
+	t.submitTime = time.Now()
+	t.notify(Submitted, nil)
+}
+
+// Queued reports that the job is waiting for resources
+func (t *JobTracker) Queued() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.queued || !t.startTime.IsZero() || t.done {
+		return
+	}
+	t.queued = true
+	t.notify(Queued, nil)
+}
+
+// Running reports that the job started running on the given host
+func (t *JobTracker) Running(host string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.startTime.IsZero() || t.done {
+		return
+	}
+	t.host = host
+	t.startTime = time.Now()
+	t.notify(Running, nil)
+}
+
+// Done reports that the job finished; a nil error means the job completed successfully
+func (t *JobTracker) Done(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.done {
+		return
+	}
+	t.done = true
+	if err != nil {
+		t.notify(Failed, err)
+	} else {
+		t.notify(Finished, nil)
+	}
+}
+
+// Skipped reports that the job was not run since it already completed
+func (t *JobTracker) Skipped() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.done {
+		return
+	}
+	t.done = true
+	t.notify(Skipped, nil)
+}
+
+func (t *JobTracker) notify(eventType EventType, err error) {
+	if len(t.listeners) == 0 {
+		return
+	}
+	e := Event{
+		Type:       eventType,
+		Processor:  t.processor,
+		JobName:    t.jobName,
+		JobID:      t.jobID,
+		Host:       t.host,
+		Time:       time.Now(),
+		SubmitTime: t.submitTime,
+		StartTime:  t.startTime,
+		Err:        err,
+	}
+	for _, l := range t.listeners {
+		l.JobEvent(e)
+	}
+}
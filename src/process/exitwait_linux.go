@@ -0,0 +1,25 @@
+package process
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+const (
+	pPID    = 1          // P_PID
+	wNoWait = 0x01000000 // WNOWAIT
+)
+
+// waitWithoutReaping blocks until the process exits but leaves it unreaped, so that its process ID,
+// which is also the ID of the job's process group, cannot be reused until the process is waited for.
+// It returns false if it could not wait for the process.
+func waitWithoutReaping(pid int) bool {
+	var siginfo [16]uint64
+	for {
+		_, _, errno := syscall.Syscall6(syscall.SYS_WAITID, pPID, uintptr(pid),
+			uintptr(unsafe.Pointer(&siginfo[0])), syscall.WEXITED|wNoWait, 0, 0)
+		if errno != syscall.EINTR {
+			return errno == 0
+		}
+	}
+}
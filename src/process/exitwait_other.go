@@ -0,0 +1,9 @@
+//go:build !linux
+// +build !linux
+
+package process
+
+// waitWithoutReaping cannot wait for a process without reaping it on this platform so it returns false right away
+func waitWithoutReaping(pid int) bool {
+	return false
+}
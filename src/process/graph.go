@@ -0,0 +1,146 @@
+package process
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"config"
+)
+
+// JobGraph is a set of jobs together with the dependencies between them
+type JobGraph struct {
+	name   string
+	nodes  []*graphNode
+	byName map[string]*graphNode
+}
+
+type graphNode struct {
+	job        Job
+	nDeps      int
+	dependents []*graphNode
+}
+
+// jobResult the result of a job from the graph
+type jobResult struct {
+	node    *graphNode
+	err     error
+	started time.Time
+}
+
+// NewJobGraph creates an empty job graph
+func NewJobGraph(name string) *JobGraph {
+	return &JobGraph{
+		name:   name,
+		byName: make(map[string]*graphNode),
+	}
+}
+
+// AddJob adds a job that can only start after all the named dependencies completed successfully.
+// Job names must be unique and the dependencies must be added to the graph before their dependents,
+// which guarantees that the graph has no cycles.
+func (g *JobGraph) AddJob(j Job, dependencies ...string) error {
+	if _, found := g.byName[j.Name]; found {
+		return fmt.Errorf("Job %s already added to %s", j.Name, g.name)
+	}
+	n := &graphNode{job: j}
+	for _, dep := range dependencies {
+		depNode, found := g.byName[dep]
+		if !found {
+			return fmt.Errorf("Dependency %s of %s not found in %s", dep, j.Name, g.name)
+		}
+		depNode.dependents = append(depNode.dependents, n)
+		n.nDeps++
+	}
+	g.nodes = append(g.nodes, n)
+	g.byName[j.Name] = n
+	return nil
+}
+
+// ProcessJobGraph runs all jobs from the graph with the given processor. A job is started as soon as all
+// its dependencies completed successfully, so independent jobs run concurrently but never more than
+// "maxRunningJobs" at a time. When a job fails only the jobs that depend on it, directly or indirectly,
+// are skipped. If the context is cancelled no more jobs are started and the jobs that did not start are
+// recorded as skipped. If any job failed the returned error is a JobErrors with the errors of the failed jobs;
+// the skipped jobs are only recorded as skipped in the run summary.
+func ProcessJobGraph(ctx context.Context, jobProcessor Processor, g *JobGraph, resources config.Config) error {
+	maxRunningJobs := resources.GetIntProperty("maxRunningJobs")
+	if maxRunningJobs <= 0 {
+		maxRunningJobs = 1
+	}
+	summary := newRunSummary(Job{Name: g.name})
+	pendingDeps := make(map[*graphNode]int, len(g.nodes))
+	var ready []*graphNode
+	for _, n := range g.nodes {
+		pendingDeps[n] = n.nDeps
+		if n.nDeps == 0 {
+			ready = append(ready, n)
+		}
+	}
+	var skip func(n *graphNode, reason error)
+	skip = func(n *graphNode, reason error) {
+		for _, dep := range n.dependents {
+			if pendingDeps[dep] < 0 {
+				// already skipped
+				continue
+			}
+			pendingDeps[dep] = -1
+			log.Printf("Skip job %s: %v", dep.job.Name, reason)
+			summary.skipped(dep.job, reason)
+			skip(dep, reason)
+		}
+	}
+
+	results := make(chan jobResult)
+	started := make(map[*graphNode]bool, len(g.nodes))
+	running := 0
+	for len(ready) > 0 || running > 0 {
+		for len(ready) > 0 && running < maxRunningJobs && ctx.Err() == nil {
+			n := ready[0]
+			ready = ready[1:]
+			started[n] = true
+			running++
+			go func(n *graphNode) {
+				started := time.Now()
+				log.Printf("Run Job: %v", n.job)
+				results <- jobResult{node: n, err: jobProcessor.Run(ctx, n.job), started: started}
+			}(n)
+		}
+		if running == 0 {
+			// cancelled before all jobs were started
+			break
+		}
+		r := <-results
+		running--
+		summary.completed(r.node.job, r.err, r.started)
+		if r.err != nil {
+			log.Printf("Job %s failed: %v", r.node.job.Name, r.err)
+			skip(r.node, fmt.Errorf("dependency %s failed", r.node.job.Name))
+			continue
+		}
+		for _, dep := range r.node.dependents {
+			if pendingDeps[dep] > 0 {
+				pendingDeps[dep]--
+				if pendingDeps[dep] == 0 {
+					ready = append(ready, dep)
+				}
+			}
+		}
+	}
+	if ctx.Err() != nil {
+		for _, n := range g.nodes {
+			if !started[n] && pendingDeps[n] >= 0 {
+				summary.skipped(n.job, ctx.Err())
+			}
+		}
+		summary.failed(g.name, ctx.Err())
+	}
+	err := summary.finish()
+	if reportDir := ReportDir(resources); reportDir != "" {
+		if writeErr := summary.write(reportDir); writeErr != nil {
+			log.Print(writeErr)
+		}
+	}
+	return err
+}
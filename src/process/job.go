@@ -1,11 +1,15 @@
 package process
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"os/exec"
+	"strconv"
+	"sync"
+	"syscall"
 	"time"
 
 	"arg"
@@ -22,22 +26,50 @@ type Job struct {
 	JArgs arg.Args
 	// CmdlineBuilder command line builder
 	CmdlineBuilder arg.CmdlineArgBuilder
+	// Requirements resources needed by the job
+	Requirements ResourceRequirements
+	// InputFiles files read by the job; a job that declares its inputs can be skipped
+	// if it already completed successfully and none of its inputs changed
+	InputFiles []string
+	// ListInputFiles lists the files read by the job only when they are needed, i.e., when the jobs are cached,
+	// for jobs whose inputs are expensive to find out; it is not used if InputFiles is set
+	ListInputFiles func() ([]string, error)
+	// Staging files the job reads and writes through a node-local scratch directory
+	// if the processor is configured with a "scratchDir"
+	Staging FileStaging
+}
+
+// CmdlineArgs builds the job's command line arguments. If the arguments cannot be built
+// it returns an InvalidArgsError since resubmitting the job cannot fix the problem.
+func (j Job) CmdlineArgs() ([]string, error) {
+	if j.CmdlineBuilder == nil {
+		return nil, &InvalidArgsError{Name: j.Name, Err: fmt.Errorf("no command line builder")}
+	}
+	cmdargs, err := j.CmdlineBuilder.GetCmdlineArgs(j.JArgs)
+	if err != nil {
+		return nil, &InvalidArgsError{Name: j.Name, Err: err}
+	}
+	return cmdargs, nil
 }
 
 // Info descriptor
 type Info interface {
 	JobStdout() (io.ReadCloser, error)
 	JobStderr() (io.ReadCloser, error)
-	WaitForTermination() error
+	// WaitForTermination waits for the job's completion or until the context is cancelled
+	WaitForTermination(ctx context.Context) error
+	// Control applies the action to the job
+	Control(action JobAction) error
 }
 
 // Processor is responsible with processing a single job
 type Processor interface {
 	// Start the given job and returns as soon as it can without waiting for job's completion.
-	// To check for the completion use Info's WaitForTermination method
-	Start(j Job) (Info, error)
-	// Run starts the given job and wait's until it completes.
-	Run(j Job) error
+	// To check for the completion use Info's WaitForTermination method.
+	// Cancelling the context stops the job.
+	Start(ctx context.Context, j Job) (Info, error)
+	// Run starts the given job and wait's until it completes or the context is cancelled.
+	Run(ctx context.Context, j Job) error
 }
 
 // JobWatcher - implements a job watcher whose main job is to wait for job's completion
@@ -45,9 +77,9 @@ type JobWatcher struct {
 }
 
 // Wait method
-func (w JobWatcher) Wait(ji Info) error {
-	if err := ji.WaitForTermination(); err != nil {
-		return fmt.Errorf("Processing error: %v", err)
+func (w JobWatcher) Wait(ctx context.Context, ji Info) error {
+	if err := ji.WaitForTermination(ctx); err != nil {
+		return fmt.Errorf("Processing error: %w", err)
 	}
 	return nil
 }
@@ -67,7 +99,12 @@ func (ej echoJobInfo) JobStderr() (io.ReadCloser, error) {
 }
 
 // WaitForTermination wait for job's completion
-func (ej echoJobInfo) WaitForTermination() error {
+func (ej echoJobInfo) WaitForTermination(ctx context.Context) error {
+	return nil
+}
+
+// Control does nothing since an echo job completes as soon as it starts
+func (ej echoJobInfo) Control(action JobAction) error {
 	return nil
 }
 
@@ -82,49 +119,76 @@ func NewEchoProcessor() Processor {
 }
 
 // Run the given job
-func (p *echoProcessor) Run(j Job) error {
-	ji, err := p.Start(j)
+func (p *echoProcessor) Run(ctx context.Context, j Job) error {
+	ji, err := p.Start(ctx, j)
 	if err != nil {
-		return fmt.Errorf("Error starting %v: %v", j, err)
+		return fmt.Errorf("Error starting %v: %w", j, err)
 	}
-	return p.Wait(ji)
+	return p.Wait(ctx, ji)
 }
 
 // Start the given job
-func (p *echoProcessor) Start(j Job) (Info, error) {
-	cmdline, err := j.CmdlineBuilder.GetCmdlineArgs(j.JArgs)
+func (p *echoProcessor) Start(ctx context.Context, j Job) (Info, error) {
+	cmdline, err := j.CmdlineArgs()
 	if err != nil {
 		return nil, err
 	}
+	tracker := NewJobTracker(ctx, "echo", j.Name)
+	tracker.Submitted("")
 	fmt.Printf("Execute %v %v %v\n", j.Name, j.Executable, cmdline)
+	tracker.Running(localHostname())
+	tracker.Done(nil)
 
 	return echoJobInfo{}, nil
 }
 
 // localCmdInfo local process info
 type localCmdInfo struct {
-	cmd       *exec.Cmd
-	jobStdout io.ReadCloser
-	jobStderr io.ReadCloser
+	cmd          *exec.Cmd
+	jobName      string
+	runtimeLimit time.Duration
+	tracker      *JobTracker
+	pool         *resourcePool
+	reserved     ResourceRequirements
+	stdoutLog    *jobLog
+	stderrLog    *jobLog
+	jobStdout    io.ReadCloser
+	jobStderr    io.ReadCloser
+	scratch      *jobScratch
+	timer        *time.Timer // kills the job when it exceeds its runtime limit
+	mu           sync.Mutex
+	exited       bool // the job's process exited so its process group must no longer be signalled
+	timedOut     bool
 }
 
 func (lci *localCmdInfo) JobStdout() (io.ReadCloser, error) {
+	if lci.stdoutLog != nil {
+		return lci.stdoutLog.open()
+	}
 	return lci.jobStdout, nil
 }
 
 func (lci *localCmdInfo) JobStderr() (io.ReadCloser, error) {
+	if lci.stderrLog != nil {
+		return lci.stderrLog.open()
+	}
 	return lci.jobStderr, nil
 }
 
-func (lci *localCmdInfo) WaitForTermination() error {
+func (lci *localCmdInfo) WaitForTermination(ctx context.Context) error {
 	var donech chan struct{}
 	var done struct{}
 	donech = make(chan struct{})
 	go func() {
+		cancelled := ctx.Done()
 		for {
 			select {
 			case <-time.After(500 * time.Millisecond):
 				lci.readOutput()
+			case <-cancelled:
+				log.Printf("Kill %v", lci.cmd.Args)
+				lci.kill()
+				cancelled = nil
 			case <-donech:
 				lci.readOutput()
 				return
@@ -132,12 +196,128 @@ func (lci *localCmdInfo) WaitForTermination() error {
 		}
 	}()
 	lci.readOutput()
+	// if the process cannot be waited for without reaping it, the process group
+	// can only be considered gone once the process was reaped
+	unreaped := waitUntilExited(lci.cmd.Process.Pid)
+	if unreaped {
+		lci.markExited()
+	}
 	err := lci.cmd.Wait()
+	if !unreaped {
+		lci.markExited()
+	}
+	lci.mu.Lock()
+	timedOut := lci.timedOut
+	lci.mu.Unlock()
 	donech <- done
+	lci.closeLogs()
+	lci.releaseResources()
+	if ctx.Err() != nil {
+		err = ctx.Err()
+	} else if timedOut && err != nil {
+		err = &TimeoutError{Name: lci.jobName, Limit: lci.runtimeLimit, Stage: RunTimeout}
+	}
+	if err == nil {
+		err = lci.scratch.stageOut()
+	}
+	lci.scratch.remove()
+	lci.tracker.Done(err)
 	return err
 }
 
+// Control terminates, suspends or resumes the job's process group. Local jobs start
+// as soon as they are submitted so they cannot be held or released.
+func (lci *localCmdInfo) Control(action JobAction) error {
+	var sig syscall.Signal
+	switch action {
+	case Terminate:
+		lci.kill()
+		return nil
+	case Suspend:
+		sig = syscall.SIGSTOP
+	case Resume:
+		sig = syscall.SIGCONT
+	default:
+		return &UnsupportedActionError{Name: lci.jobName, Action: action}
+	}
+	if err := lci.signalGroup(sig); err != nil {
+		return fmt.Errorf("Error sending %v to the process group %d: %v", sig, lci.cmd.Process.Pid, err)
+	}
+	return nil
+}
+
+// waitUntilExited waits for the job's process to exit and reports whether the process is left unreaped
+var waitUntilExited = waitWithoutReaping
+
+// markExited records that the job's process exited and stops its runtime limit timer
+func (lci *localCmdInfo) markExited() {
+	lci.mu.Lock()
+	lci.exited = true
+	lci.mu.Unlock()
+	if lci.timer != nil {
+		lci.timer.Stop()
+	}
+}
+
+// signalGroup sends the signal to the job's process group unless the job's process already exited,
+// in which case the process group ID may be reused as soon as the process is reaped
+func (lci *localCmdInfo) signalGroup(sig syscall.Signal) error {
+	lci.mu.Lock()
+	defer lci.mu.Unlock()
+	if lci.exited {
+		return nil
+	}
+	return syscall.Kill(-lci.cmd.Process.Pid, sig)
+}
+
+// kill kills the job's entire process group so that no process spawned by the job is left behind
+func (lci *localCmdInfo) kill() {
+	if err := lci.signalGroup(syscall.SIGKILL); err != nil {
+		log.Printf("Error killing the process group %d: %v", lci.cmd.Process.Pid, err)
+		lci.cmd.Process.Kill()
+	}
+}
+
+// timeout kills the job after it exceeded its runtime limit unless it already exited
+func (lci *localCmdInfo) timeout() {
+	lci.mu.Lock()
+	defer lci.mu.Unlock()
+	if lci.exited {
+		return
+	}
+	log.Printf("Kill %v after it exceeded the %v runtime limit", lci.cmd.Args, lci.runtimeLimit)
+	lci.timedOut = true
+	if err := syscall.Kill(-lci.cmd.Process.Pid, syscall.SIGKILL); err != nil {
+		log.Printf("Error killing the process group %d: %v", lci.cmd.Process.Pid, err)
+		lci.cmd.Process.Kill()
+	}
+}
+
+// releaseResources gives the job's resources back to the pool
+func (lci *localCmdInfo) releaseResources() {
+	if lci.pool != nil {
+		lci.pool.release(lci.reserved)
+		lci.pool = nil
+	}
+}
+
+// closeLogs closes the job's log files
+func (lci *localCmdInfo) closeLogs() {
+	for _, jl := range []*jobLog{lci.stdoutLog, lci.stderrLog} {
+		if jl == nil {
+			continue
+		}
+		if err := jl.close(); err != nil {
+			log.Printf("Error closing %s: %v", jl.name, err)
+		}
+	}
+}
+
+// readOutput copies the job's output to the console when it is not written to log files
 func (lci *localCmdInfo) readOutput() {
+	if lci.stdoutLog != nil {
+		return
+	}
 	io.Copy(os.Stdout, lci.jobStdout)
 	io.Copy(os.Stderr, lci.jobStderr)
 }
@@ -146,46 +326,157 @@ func (lci *localCmdInfo) readOutput() {
 type localCmdProcessor struct {
 	JobWatcher
 	Resources config.Config
+	pool      *resourcePool
 }
 
 // NewLocalCmdProcessor creates a local command job processor that processes the job on the current machine
-// by creating and calling the corresponding command.
+// by creating and calling the corresponding command. A job is only started when the machine has enough
+// free slots and memory for the job's requirements; all local processors share the same machine capacity.
 func NewLocalCmdProcessor(resources config.Config) Processor {
-	return &localCmdProcessor{Resources: resources}
+	return &localCmdProcessor{
+		Resources: resources,
+		pool:      localResourcePool(resources),
+	}
 }
 
 // Run the given job
-func (p *localCmdProcessor) Run(j Job) error {
-	ji, err := p.Start(j)
+func (p *localCmdProcessor) Run(ctx context.Context, j Job) error {
+	ji, err := p.Start(ctx, j)
 	if err != nil {
-		return fmt.Errorf("Error starting %v: %v", j, err)
+		return fmt.Errorf("Error starting %v: %w", j, err)
 	}
-	return p.Wait(ji)
+	return p.Wait(ctx, ji)
 }
 
-// Start launches the server
-func (p *localCmdProcessor) Start(j Job) (Info, error) {
-	cmdargs, err := j.CmdlineBuilder.GetCmdlineArgs(j.JArgs)
+// Start launches the server. If "scratchDir" is set the files the job stages are copied
+// to a new directory in "scratchDir" before the job starts and its outputs are moved back
+// when it completes successfully.
+func (p *localCmdProcessor) Start(ctx context.Context, j Job) (Info, error) {
+	tracker := NewJobTracker(ctx, "local", j.Name)
+	j, scratch, err := stageJobIn(j, p.Resources.GetStringProperty("scratchDir"))
 	if err != nil {
-		return nil, fmt.Errorf("Error preparing the command line arguments: %v", err)
+		tracker.Done(err)
+		return nil, err
 	}
-	cmd := exec.Command(j.Executable, cmdargs...)
-	log.Printf("Execute %v\n", cmd)
-	stdout, err := cmd.StdoutPipe()
+	cmdargs, err := j.CmdlineArgs()
 	if err != nil {
-		return nil, fmt.Errorf("Error opening the command stdout: %v", err)
+		scratch.remove()
+		err = fmt.Errorf("Error preparing the command line arguments: %w", err)
+		tracker.Done(err)
+		return nil, err
 	}
-	stderr, err := cmd.StderrPipe()
+	// the command is killed if the context is cancelled before the command completes
+	cmd := exec.CommandContext(ctx, j.Executable, cmdargs...)
+	// run the job in its own process group so that it can be killed together with all its children
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	log.Printf("Execute %v\n", cmd)
+	reserved, err := p.pool.acquire(ctx, j.Name, p.jobRequirements(j))
 	if err != nil {
-		return nil, fmt.Errorf("Error opening the command stderr: %v", err)
+		scratch.remove()
+		tracker.Done(err)
+		return nil, err
 	}
+	cmd.Env = localJobEnv(reserved)
 	lci := &localCmdInfo{
-		cmd:       cmd,
-		jobStdout: stdout,
-		jobStderr: stderr,
+		cmd:          cmd,
+		jobName:      j.Name,
+		runtimeLimit: p.jobRuntimeLimit(j),
+		tracker:      tracker,
+		pool:         p.pool,
+		reserved:     reserved,
+		scratch:      scratch,
+	}
+	if err = p.captureOutput(j, lci); err != nil {
+		lci.releaseResources()
+		scratch.remove()
+		tracker.Done(err)
+		return nil, err
+	}
+	if err = cmd.Start(); err != nil {
+		lci.closeLogs()
+		lci.releaseResources()
+		scratch.remove()
+		tracker.Done(err)
+		return nil, err
+	}
+	if lci.stdoutLog != nil {
+		lci.stdoutLog.rename(j.Name, cmd.Process.Pid)
+		lci.stderrLog.rename(j.Name, cmd.Process.Pid)
+	}
+	if lci.runtimeLimit > 0 {
+		// the limit applies from the start even if nobody waits for the job yet, e.g.,
+		// the DMG server while its clients are being started
+		lci.timer = time.AfterFunc(lci.runtimeLimit, lci.timeout)
+	}
+	lci.tracker.Submitted(strconv.Itoa(cmd.Process.Pid))
+	lci.tracker.Running(localHostname())
+	return lci, nil
+}
+
+// captureOutput writes the job's output to <jobName>.o<pid> in "outputDir" and <jobName>.e<pid> in "errorDir",
+// which defaults to "outputDir". If "teeJobOutput" is set the output is also copied to the console with every line
+// prefixed by the job's name. If no "outputDir" is configured the output is only copied to the console.
+func (p *localCmdProcessor) captureOutput(j Job, lci *localCmdInfo) error {
+	var err error
+	outputDir := p.Resources.GetStringProperty("outputDir")
+	if outputDir == "" {
+		if lci.jobStdout, err = lci.cmd.StdoutPipe(); err != nil {
+			return fmt.Errorf("Error opening the command stdout: %v", err)
+		}
+		if lci.jobStderr, err = lci.cmd.StderrPipe(); err != nil {
+			return fmt.Errorf("Error opening the command stderr: %v", err)
+		}
+		return nil
+	}
+	errorDir := p.Resources.GetStringProperty("errorDir")
+	if errorDir == "" {
+		errorDir = outputDir
+	}
+	if lci.stdoutLog, err = createJobLog(outputDir, j.Name, "o"); err != nil {
+		return err
+	}
+	if lci.stderrLog, err = createJobLog(errorDir, j.Name, "e"); err != nil {
+		lci.closeLogs()
+		return err
+	}
+	if p.Resources.GetBoolProperty("teeJobOutput") {
+		lci.stdoutLog.tee(os.Stdout, j.Name)
+		lci.stderrLog.tee(os.Stderr, j.Name)
 	}
-	err = cmd.Start()
-	return lci, err
+	lci.cmd.Stdout = lci.stdoutLog.writer()
+	lci.cmd.Stderr = lci.stderrLog.writer()
+	return nil
+}
+
+// jobRequirements returns the job's requirements; the requirements that neither the job nor its
+// role's profile declare default to 1 slot and "jobMemory" MB
+func (p *localCmdProcessor) jobRequirements(j Job) ResourceRequirements {
+	r := JobRequirements(j, p.Resources)
+	if r.Slots <= 0 {
+		r.Slots = 1
+	}
+	if r.MemoryMB <= 0 {
+		r.MemoryMB = p.Resources.GetIntProperty("jobMemory")
+	}
+	return r
+}
+
+// jobRuntimeLimit returns the runtime limit of the job or of its role's profile which defaults to
+// "jobTimeout" seconds; unlike for grid jobs, local jobs have no runtime limit if none is set
+func (p *localCmdProcessor) jobRuntimeLimit(j Job) time.Duration {
+	if r := JobRequirements(j, p.Resources); r.RuntimeLimit > 0 {
+		return r.RuntimeLimit
+	}
+	return time.Duration(p.Resources.GetInt64Property("jobTimeout")) * time.Second
+}
+
+// localHostname returns the name of the host the local jobs run on
+func localHostname() string {
+	host, err := os.Hostname()
+	if err != nil {
+		return "localhost"
+	}
+	return host
 }
 
 // parallelProcessor is responsible with splitting a job into multiple smaller jobs
@@ -205,6 +496,8 @@ type Splitter interface {
 
 // NewParallelProcessor creates a new job processor that will process the job by
 // first splitting it into multiple smaller jobs and than apply the given subJob processor.
+// At most "maxRunningJobs" subjobs run at the same time unless "submitAsHold" is set, in which case
+// "maxRunningJobs" only limits the concurrent submissions and all the subjobs wait for their release.
 func NewParallelProcessor(jobProcessor Processor, jobSplitter Splitter, resources config.Config) Processor {
 	return &parallelProcessor{
 		resources:    resources,
@@ -217,111 +510,214 @@ func NewParallelProcessor(jobProcessor Processor, jobSplitter Splitter, resource
 // parallelJob information about a parallel job
 type parallelJob struct {
 	done chan struct{}
+	err  error
 }
 
 // JobStdout a parallel job's standard output
-func (pj parallelJob) JobStdout() (io.ReadCloser, error) {
+func (pj *parallelJob) JobStdout() (io.ReadCloser, error) {
 	return os.Stdout, nil
 }
 
 // JobStderr a parallel job's standard error
-func (pj parallelJob) JobStderr() (io.ReadCloser, error) {
+func (pj *parallelJob) JobStderr() (io.ReadCloser, error) {
 	return os.Stderr, nil
 }
 
-// WaitForTermination wait for job's completion
-func (pj parallelJob) WaitForTermination() error {
-	<-pj.done
+// WaitForTermination wait for job's completion. If any subjob failed
+// the returned error is a JobErrors that contains the errors of all failed subjobs
+func (pj *parallelJob) WaitForTermination(ctx context.Context) error {
+	select {
+	case <-pj.done:
+		return pj.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Control does nothing since a parallel job is only returned after all its subjobs completed
+func (pj *parallelJob) Control(action JobAction) error {
 	return nil
 }
 
 // Run the given job
-func (p *parallelProcessor) Run(j Job) error {
-	ji, err := p.Start(j)
+func (p *parallelProcessor) Run(ctx context.Context, j Job) error {
+	ji, err := p.Start(ctx, j)
 	if err != nil {
-		return fmt.Errorf("Error starting %v: %v", j, err)
+		return fmt.Errorf("Error starting %v: %w", j, err)
 	}
-	return p.Wait(ji)
+	return p.Wait(ctx, ji)
 }
 
 // Start the given job
-func (p *parallelProcessor) Start(j Job) (Info, error) {
+func (p *parallelProcessor) Start(ctx context.Context, j Job) (Info, error) {
 	maxRunningJobs := p.resources.GetIntProperty("maxRunningJobs")
 	if maxRunningJobs <= 0 {
 		maxRunningJobs = 1
 	}
-	workerPool := make(chan *processWorker, maxRunningJobs)
 
-	jobInfo := parallelJob{
-		done: make(chan struct{}, 1),
+	var journal *jobJournal
+	if journalDir := p.resources.GetStringProperty("journalDir"); journalDir != "" && p.isResumable() {
+		var err error
+		if journal, err = openJobJournal(journalDir, j); err != nil {
+			return nil, err
+		}
+		defer journal.close()
 	}
 
-	stopWorkers := func() {
-		var done struct{}
-
-		for i := 0; i < maxRunningJobs; i++ {
-			w, ok := <-workerPool
-			if !ok {
-				continue
+	jobInfo := &parallelJob{
+		done: make(chan struct{}),
+	}
+	summary := newRunSummary(j)
+	tracker := NewJobTracker(ctx, "parallel", j.Name)
+	tracker.Submitted("")
+	tracker.Running(localHostname())
+	ctx = withRunSummary(ctx, summary)
+
+	jch, splitErrc := p.splitJob(j)
+	if bp, bulkJobSize := p.bulkProcessor(); bp != nil {
+		p.processBulk(ctx, j, bp, bulkJobSize, jch, journal, summary)
+	} else {
+		p.processWithWorkers(ctx, maxRunningJobs, jch, journal, summary)
+	}
+	if ctx.Err() != nil {
+		log.Printf("Job %s cancelled: %v", j.Name, ctx.Err())
+		// drain the remaining jobs so that the splitter can complete
+		go func() {
+			for range jch {
 			}
-			w.quit <- done
-			<-w.done
-		}
-		jobInfo.done <- done
+		}()
+		summary.failed(j.Name, ctx.Err())
+	} else if err := <-splitErrc; err != nil {
+		summary.failed(j.Name, fmt.Errorf("Error splitting the job: %v", err))
 	}
 
-	defer stopWorkers()
+	jobInfo.err = summary.finish()
+	tracker.Done(jobInfo.err)
+	if reportDir := ReportDir(p.resources); reportDir != "" {
+		if err := summary.write(reportDir); err != nil {
+			log.Print(err)
+		}
+	}
+	close(jobInfo.done)
+	return jobInfo, nil
+}
 
+// processWithWorkers runs the subjobs with at most maxRunningJobs workers. If the subjobs are submitted as held
+// the workers do not wait for their completion so that all the subjobs can be released together.
+func (p *parallelProcessor) processWithWorkers(ctx context.Context, maxRunningJobs int, jch <-chan Job, journal *jobJournal, summary *runSummary) {
+	var held *sync.WaitGroup
+	if p.resources.GetBoolProperty("submitAsHold") {
+		held = &sync.WaitGroup{}
+		defer held.Wait()
+	}
+	workerPool := make(chan *processWorker, maxRunningJobs)
 	for i := 0; i < maxRunningJobs; i++ {
 		quit := make(chan struct{}, 1)
-		errc := make(chan error, 1)
 
-		startWorker(errc, quit, workerPool, p.jobProcessor)
+		startWorker(ctx, quit, workerPool, p.jobProcessor, journal, summary, held)
 	}
-	jch := p.splitJob(j)
-
-	var processingErr error
 	for sj := range jch {
+		fingerprint, completed := isCompletedJob(sj, journal, summary)
+		if completed {
+			continue
+		}
 		w := <-workerPool
-		w.jobQueue <- sj
-		if jobError := w.getError(); jobError != nil {
-			processingErr = jobError
+		if ctx.Err() != nil {
+			// give the worker back to the pool and stop pulling jobs from the splitter
+			workerPool <- w
+			break
 		}
+		w.jobQueue <- scheduledJob{job: sj, fingerprint: fingerprint}
 	}
+	stopWorkers(workerPool, maxRunningJobs)
+}
+
+// isCompletedJob checks the journal to find out if the subjob already completed successfully in a previous run.
+// It returns the job's fingerprint which must be used to record the job's outcome in the journal.
+func isCompletedJob(sj Job, journal *jobJournal, summary *runSummary) (string, bool) {
+	if journal == nil {
+		return "", false
+	}
+	fingerprint, err := jobFingerprint(sj)
+	if err != nil {
+		log.Printf("Error computing the fingerprint for %s: %v", sj.Name, err)
+		return "", false
+	}
+	if journal.isCompleted(sj, fingerprint) {
+		log.Printf("Skip job %s - it already completed in a previous run", sj.Name)
+		summary.skipped(sj, nil)
+		return fingerprint, true
+	}
+	return fingerprint, false
+}
+
+// isResumable checks if the subjobs completed in a previous run can be skipped
+func (p *parallelProcessor) isResumable() bool {
+	if rs, ok := p.jobSplitter.(ResumableSplitter); ok {
+		return rs.Resumable()
+	}
+	return true
+}
 
-	return jobInfo, processingErr
+// scheduledJob a subjob handed to a worker
+type scheduledJob struct {
+	job         Job
+	fingerprint string
 }
 
 type processWorker struct {
-	jobQueue chan Job
+	ctx      context.Context
+	jobQueue chan scheduledJob
 	pool     chan *processWorker
-	errc     chan error
 	quit     chan struct{}
 	done     chan bool
 	jp       Processor
+	journal  *jobJournal
+	summary  *runSummary
+	// held the held subjobs the worker submitted and that did not complete yet; nil if the subjobs are not held
+	held *sync.WaitGroup
 }
 
-func startWorker(errc chan error, quit chan struct{}, pool chan *processWorker, jp Processor) {
+func startWorker(ctx context.Context, quit chan struct{}, pool chan *processWorker, jp Processor, journal *jobJournal, summary *runSummary, held *sync.WaitGroup) {
 	w := &processWorker{
-		jobQueue: make(chan Job),
+		ctx:      ctx,
+		jobQueue: make(chan scheduledJob),
 		pool:     pool,
-		errc:     errc,
 		quit:     quit,
 		done:     make(chan bool, 1),
 		jp:       jp,
+		journal:  journal,
+		summary:  summary,
+		held:     held,
 	}
 	go w.run()
 }
 
-// splitJob splits the job into multiple smaller jobs
-func (p *parallelProcessor) splitJob(j Job) chan Job {
+// stopWorkers waits for all workers to finish their current job and stops them
+func stopWorkers(workerPool chan *processWorker, nWorkers int) {
+	var done struct{}
+
+	for i := 0; i < nWorkers; i++ {
+		w, ok := <-workerPool
+		if !ok {
+			continue
+		}
+		w.quit <- done
+		<-w.done
+	}
+}
+
+// splitJob splits the job into multiple smaller jobs. The returned error channel
+// receives the splitter's result after all subjobs have been generated
+func (p *parallelProcessor) splitJob(j Job) (chan Job, chan error) {
 	jobQueueSize := p.resources.GetIntProperty("jobQueueSize")
 	jch := make(chan Job, jobQueueSize)
+	errc := make(chan error, 1)
 	go func() {
-		p.jobSplitter.SplitJob(j, jch)
+		errc <- p.jobSplitter.SplitJob(j, jch)
 		close(jch)
 	}()
-	return jch
+	return jch, errc
 }
 
 func (w *processWorker) run() {
@@ -337,18 +733,20 @@ func (w *processWorker) run() {
 		// tell the dispatcher that this worker is ready to accept more work
 		w.pool <- w
 		select {
-		case j, ok := <-w.jobQueue:
+		case sj, ok := <-w.jobQueue:
 			if !ok {
 				done = true
 				w.done <- done
 				return
 			}
-			// run the job
-			log.Printf("Run Job: %v", j)
-			if err := w.jp.Run(j); err != nil {
-				log.Println(err)
-				w.setError(err)
+			if w.held != nil {
+				w.startHeld(sj)
+				continue
 			}
+			// run the job
+			log.Printf("Run Job: %v", sj.job)
+			started := time.Now()
+			w.record(sj, w.jp.Run(w.ctx, sj.job), started)
 		case <-w.quit:
 			// done
 			done = true
@@ -358,20 +756,29 @@ func (w *processWorker) run() {
 	}
 }
 
-func (w *processWorker) setError(err error) {
-	select {
-	case w.errc <- err:
-		return
-	default:
+// startHeld submits the held job and waits for its completion in the background
+func (w *processWorker) startHeld(sj scheduledJob) {
+	log.Printf("Submit held Job: %v", sj.job)
+	started := time.Now()
+	ji, err := w.jp.Start(w.ctx, sj.job)
+	if err != nil {
+		w.record(sj, fmt.Errorf("Error starting %v: %w", sj.job, err), started)
 		return
 	}
+	w.held.Add(1)
+	go func() {
+		defer w.held.Done()
+		w.record(sj, ji.WaitForTermination(w.ctx), started)
+	}()
 }
 
-func (w *processWorker) getError() (err error) {
-	select {
-	case err = <-w.errc:
-		return
-	default:
-		return
+// record the outcome of the job in the run summary and in the journal
+func (w *processWorker) record(sj scheduledJob, err error, started time.Time) {
+	if err != nil {
+		log.Println(err)
+	}
+	w.summary.completed(sj.job, err, started)
+	if w.journal != nil {
+		w.journal.record(sj.job, sj.fingerprint, err)
 	}
 }
@@ -0,0 +1,973 @@
+package process
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"arg"
+	"config"
+)
+
+// testSplitter splits a job into nJobs subjobs
+type testSplitter struct {
+	nJobs int
+}
+
+func (s testSplitter) SplitJob(j Job, jch chan<- Job) error {
+	for i := 0; i < s.nJobs; i++ {
+		jch <- Job{
+			Executable:     j.Executable,
+			Name:           fmt.Sprintf("%s_%d", j.Name, i),
+			CmdlineBuilder: j.CmdlineBuilder,
+		}
+	}
+	return nil
+}
+
+type testCmdlineBuilder struct {
+}
+
+func (clb testCmdlineBuilder) GetCmdlineArgs(a arg.Args) ([]string, error) {
+	return []string{"-test"}, nil
+}
+
+// testProcessor records the jobs it runs and fails the ones listed in failures
+type testProcessor struct {
+	JobWatcher
+	mu       sync.Mutex
+	runs     map[string]int
+	failures map[string]bool
+}
+
+func newTestProcessor(failures ...string) *testProcessor {
+	p := &testProcessor{
+		runs:     make(map[string]int),
+		failures: make(map[string]bool),
+	}
+	for _, f := range failures {
+		p.failures[f] = true
+	}
+	return p
+}
+
+func (p *testProcessor) Run(ctx context.Context, j Job) error {
+	ji, err := p.Start(ctx, j)
+	if err != nil {
+		return err
+	}
+	return p.Wait(ctx, ji)
+}
+
+func (p *testProcessor) Start(ctx context.Context, j Job) (Info, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.runs[j.Name]++
+	tracker := NewJobTracker(ctx, "test", j.Name)
+	tracker.Submitted("")
+	if p.failures[j.Name] {
+		err := &testFailure{name: j.Name}
+		tracker.Done(err)
+		return nil, err
+	}
+	tracker.Running("")
+	tracker.Done(nil)
+	return echoJobInfo{}, nil
+}
+
+// testFailure a transient failure of a test job
+type testFailure struct {
+	name string
+}
+
+func (e *testFailure) Error() string {
+	return fmt.Sprintf("%s failed", e.name)
+}
+
+func (e *testFailure) Retriable() bool {
+	return true
+}
+
+func testJob() Job {
+	return Job{
+		Executable:     "test",
+		Name:           "test",
+		CmdlineBuilder: testCmdlineBuilder{},
+	}
+}
+
+func TestParallelProcessorReportsAllFailures(t *testing.T) {
+	reportDir, err := ioutil.TempDir("", "report")
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	defer os.RemoveAll(reportDir)
+
+	resources := config.Config{"maxRunningJobs": 3, "reportDir": reportDir}
+	jp := newTestProcessor("test_1", "test_4", "test_7")
+	err = NewParallelProcessor(jp, testSplitter{10}, resources).Run(context.Background(), testJob())
+	var jobErrs JobErrors
+	if !errors.As(err, &jobErrs) {
+		t.Fatal("Expected JobErrors but got", err)
+	}
+	if len(jobErrs) != 3 {
+		t.Error("Expected 3 failed jobs but got", len(jobErrs), jobErrs)
+	}
+	if len(jp.runs) != 10 {
+		t.Error("Expected 10 jobs to run but got", len(jp.runs))
+	}
+
+	summaryJSON, err := ioutil.ReadFile(filepath.Join(reportDir, "test-summary.json"))
+	if err != nil {
+		t.Fatal("Unexpected error reading the summary", err)
+	}
+	var summary runSummary
+	if err = json.Unmarshal(summaryJSON, &summary); err != nil {
+		t.Fatal("Unexpected error decoding the summary", err)
+	}
+	if summary.Succeeded != 7 || summary.Failed != 3 || summary.Skipped != 0 || len(summary.Subjobs) != 10 {
+		t.Error("Unexpected summary", summary.Succeeded, summary.Failed, summary.Skipped, len(summary.Subjobs))
+	}
+}
+
+func TestParallelProcessorResumesFromJournal(t *testing.T) {
+	journalDir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	defer os.RemoveAll(journalDir)
+
+	resources := config.Config{"maxRunningJobs": 2, "journalDir": journalDir}
+	firstRun := newTestProcessor("test_2", "test_3")
+	if err = NewParallelProcessor(firstRun, testSplitter{5}, resources).Run(context.Background(), testJob()); err == nil {
+		t.Error("Expected the first run to fail")
+	}
+
+	secondRun := newTestProcessor()
+	if err = NewParallelProcessor(secondRun, testSplitter{5}, resources).Run(context.Background(), testJob()); err != nil {
+		t.Error("Unexpected error", err)
+	}
+	if len(secondRun.runs) != 2 || secondRun.runs["test_2"] != 1 || secondRun.runs["test_3"] != 1 {
+		t.Error("Expected only the failed jobs to be rerun but got", secondRun.runs)
+	}
+}
+
+func TestParallelProcessorRerunsJournaledJobsWhoseFingerprintChanged(t *testing.T) {
+	journalDir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	defer os.RemoveAll(journalDir)
+
+	resources := config.Config{"maxRunningJobs": 2, "journalDir": journalDir}
+	changedExecutable := testJob()
+	changedExecutable.Executable = "other"
+	changedCmdline := changedExecutable
+	changedCmdline.CmdlineBuilder = argsBuilder{"-other"}
+	for _, td := range []struct {
+		name         string
+		j            Job
+		expectedRuns int
+	}{
+		{"first run", testJob(), 3},
+		{"unchanged rerun", testJob(), 0},
+		{"changed executable", changedExecutable, 3},
+		{"changed command line", changedCmdline, 3},
+		{"rerun after the change", changedCmdline, 0},
+	} {
+		p := newTestProcessor()
+		if err = NewParallelProcessor(p, testSplitter{3}, resources).Run(context.Background(), td.j); err != nil {
+			t.Fatal(td.name, "unexpected error", err)
+		}
+		runs := 0
+		for _, n := range p.runs {
+			runs += n
+		}
+		if runs != td.expectedRuns {
+			t.Errorf("%s: expected %d subjobs to run but got %v", td.name, td.expectedRuns, p.runs)
+		}
+	}
+}
+
+// flakyProcessor fails the first nFailures attempts with the given error
+type flakyProcessor struct {
+	*testProcessor
+	nFailures int
+	err       error
+}
+
+func (p *flakyProcessor) Run(ctx context.Context, j Job) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.runs[j.Name]++
+	if p.runs[j.Name] <= p.nFailures {
+		return p.err
+	}
+	return nil
+}
+
+func TestRetryProcessing(t *testing.T) {
+	newRetryProcessor := func(jp Processor) Processor {
+		return &retryProcessor{jobProcessor: jp, maxAttempts: 3, backoff: time.Millisecond, maxBackoff: time.Millisecond}
+	}
+
+	transient := &flakyProcessor{testProcessor: newTestProcessor(), nFailures: 2, err: &TimeoutError{Name: "test"}}
+	if err := newRetryProcessor(transient).Run(context.Background(), testJob()); err != nil {
+		t.Error("Unexpected error", err)
+	}
+	if transient.runs["test"] != 3 {
+		t.Error("Expected 3 attempts but got", transient.runs["test"])
+	}
+
+	invalid := &flakyProcessor{testProcessor: newTestProcessor(), nFailures: 2, err: &InvalidArgsError{Name: "test"}}
+	if err := newRetryProcessor(invalid).Run(context.Background(), testJob()); err == nil {
+		t.Error("Expected the invalid job to fail")
+	}
+	if invalid.runs["test"] != 1 {
+		t.Error("Expected the invalid job not to be retried but got", invalid.runs["test"], "attempts")
+	}
+
+	unknown := &flakyProcessor{testProcessor: newTestProcessor(), nFailures: 2, err: fmt.Errorf("exit status 1")}
+	if err := newRetryProcessor(unknown).Run(context.Background(), testJob()); err == nil {
+		t.Error("Expected the job to fail")
+	}
+	if unknown.runs["test"] != 1 {
+		t.Error("Expected an unclassified failure not to be retried but got", unknown.runs["test"], "attempts")
+	}
+
+	mixed := JobErrors{
+		{Name: "timeout", Err: &TimeoutError{Name: "timeout"}},
+		{Name: "invalid", Err: &InvalidArgsError{Name: "invalid"}},
+	}
+	if IsRetriable(mixed) {
+		t.Error("Expected the parallel job not to be retriable when one of its subjobs can never succeed")
+	}
+	if !IsRetriable(mixed[:1]) {
+		t.Error("Expected the parallel job to be retriable when all its failures are transient")
+	}
+}
+
+func TestCacheProcessingSkipsUnchangedJobs(t *testing.T) {
+	cacheDir, err := ioutil.TempDir("", "cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(cacheDir)
+	inputFile := filepath.Join(cacheDir, "input.tif")
+	if err = ioutil.WriteFile(inputFile, []byte("z1"), 0664); err != nil {
+		t.Fatal(err)
+	}
+	jp := newTestProcessor()
+	p := CacheProcessing(config.Config{"jobCacheDir": cacheDir, "jobCacheHashInputs": true})(jp)
+	j := testJob()
+	j.InputFiles = []string{inputFile}
+
+	for i := 0; i < 2; i++ {
+		if err = p.Run(context.Background(), j); err != nil {
+			t.Fatal("Unexpected error", err)
+		}
+	}
+	if jp.runs["test"] != 1 {
+		t.Error("Expected the unchanged job to run once but it ran", jp.runs["test"], "times")
+	}
+
+	if err = ioutil.WriteFile(inputFile, []byte("z2"), 0664); err != nil {
+		t.Fatal(err)
+	}
+	ji, err := p.Start(context.Background(), j)
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if err = ji.WaitForTermination(context.Background()); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if jp.runs["test"] != 2 {
+		t.Error("Expected the job to run again after its input changed but it ran", jp.runs["test"], "times")
+	}
+
+	uncached := testJob()
+	uncached.Name = "uncached"
+	for i := 0; i < 2; i++ {
+		p.Run(context.Background(), uncached)
+	}
+	if jp.runs["uncached"] != 2 {
+		t.Error("Expected the job without inputs not to be cached but it ran", jp.runs["uncached"], "times")
+	}
+}
+
+// listedInputsSplitter splits a job into nJobs subjobs that list the given input file only when asked
+type listedInputsSplitter struct {
+	nJobs     int
+	inputFile string
+	listed    *int32
+}
+
+func (s listedInputsSplitter) SplitJob(j Job, jch chan<- Job) error {
+	for i := 0; i < s.nJobs; i++ {
+		jch <- Job{
+			Executable:     j.Executable,
+			Name:           fmt.Sprintf("%s_%d", j.Name, i),
+			CmdlineBuilder: j.CmdlineBuilder,
+			ListInputFiles: func() ([]string, error) {
+				atomic.AddInt32(s.listed, 1)
+				return []string{s.inputFile}, nil
+			},
+		}
+	}
+	return nil
+}
+
+func TestCachedSubjobsAreReportedAsSkipped(t *testing.T) {
+	cacheDir, err := ioutil.TempDir("", "cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(cacheDir)
+	inputFile := filepath.Join(cacheDir, "input.tif")
+	if err = ioutil.WriteFile(inputFile, []byte("z1"), 0664); err != nil {
+		t.Fatal(err)
+	}
+	var listed int32
+	splitter := listedInputsSplitter{nJobs: 3, inputFile: inputFile, listed: &listed}
+	resources := config.Config{"maxRunningJobs": 2, "reportDir": cacheDir}
+	jp := newTestProcessor()
+
+	if err = NewParallelProcessor(CacheProcessing(resources)(jp), splitter, resources).Run(context.Background(), testJob()); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if listed != 0 {
+		t.Error("Expected the inputs not to be listed without a cache but they were listed", listed, "times")
+	}
+
+	resources["jobCacheDir"] = filepath.Join(cacheDir, "cache")
+	var mu sync.Mutex
+	var skippedEvents []string
+	ctx := WithEventListener(context.Background(), EventListenerFunc(func(e Event) {
+		if e.Type == Skipped {
+			mu.Lock()
+			skippedEvents = append(skippedEvents, e.JobName)
+			mu.Unlock()
+		}
+	}))
+	for i := 0; i < 2; i++ {
+		// only the last run is checked since the subjobs share the same fingerprint
+		skippedEvents = nil
+		if err = NewParallelProcessor(CacheProcessing(resources)(jp), splitter, resources).Run(ctx, testJob()); err != nil {
+			t.Fatal("Unexpected error", err)
+		}
+	}
+	if listed == 0 {
+		t.Error("Expected the inputs to be listed when the jobs are cached")
+	}
+	summaryJSON, err := ioutil.ReadFile(filepath.Join(cacheDir, "test-summary.json"))
+	if err != nil {
+		t.Fatal("Unexpected error reading the summary", err)
+	}
+	var summary runSummary
+	if err = json.Unmarshal(summaryJSON, &summary); err != nil {
+		t.Fatal("Unexpected error decoding the summary", err)
+	}
+	if summary.Succeeded != 0 || summary.Skipped != 3 || len(summary.Subjobs) != 3 {
+		t.Error("Expected all cached subjobs to be skipped but got", summary.Succeeded, summary.Skipped, len(summary.Subjobs))
+	}
+	if len(skippedEvents) != 3 {
+		t.Error("Expected a skip event for every cached subjob but got", skippedEvents)
+	}
+}
+
+func TestProcessJobGraphSkipsDependentsOfFailedJobs(t *testing.T) {
+	g := NewJobGraph("pipeline")
+	newJob := func(name string) Job {
+		j := testJob()
+		j.Name = name
+		return j
+	}
+	if err := g.AddJob(newJob("xy")); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	for _, name := range []string{"xz", "zy"} {
+		if err := g.AddJob(newJob(name), "xy"); err != nil {
+			t.Fatal("Unexpected error", err)
+		}
+	}
+	if err := g.AddJob(newJob("xz_scale"), "xz"); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if err := g.AddJob(newJob("xy"), "xz"); err == nil {
+		t.Error("Expected an error for a duplicate job")
+	}
+	if err := g.AddJob(newJob("missing"), "unknown"); err == nil {
+		t.Error("Expected an error for an unknown dependency")
+	}
+
+	reportDir, err := ioutil.TempDir("", "report")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(reportDir)
+	jp := newTestProcessor("xz")
+	err = ProcessJobGraph(context.Background(), jp, g, config.Config{"maxRunningJobs": 2, "reportDir": reportDir})
+	var jobErrs JobErrors
+	if !errors.As(err, &jobErrs) {
+		t.Fatal("Expected JobErrors but got", err)
+	}
+	if len(jobErrs) != 1 || jobErrs[0].Name != "xz" {
+		t.Error("Expected only the failed job to be reported but got", jobErrs)
+	}
+	if jp.runs["xy"] != 1 || jp.runs["xz"] != 1 || jp.runs["zy"] != 1 || jp.runs["xz_scale"] != 0 {
+		t.Error("Unexpected runs", jp.runs)
+	}
+	summaryJSON, err := ioutil.ReadFile(filepath.Join(reportDir, "pipeline-summary.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var summary runSummary
+	if err = json.Unmarshal(summaryJSON, &summary); err != nil {
+		t.Fatal(err)
+	}
+	if summary.Succeeded != 2 || summary.Failed != 1 || summary.Skipped != 1 {
+		t.Errorf("Expected 2 succeeded, 1 failed and 1 skipped but got %d, %d and %d",
+			summary.Succeeded, summary.Failed, summary.Skipped)
+	}
+}
+
+// cancellingProcessor cancels the context when it runs the named job
+type cancellingProcessor struct {
+	*testProcessor
+	name   string
+	cancel context.CancelFunc
+}
+
+func (p cancellingProcessor) Run(ctx context.Context, j Job) error {
+	if j.Name == p.name {
+		p.cancel()
+	}
+	return p.testProcessor.Run(ctx, j)
+}
+
+func TestProcessJobGraphSkipsJobsNotStartedBeforeCancel(t *testing.T) {
+	g := NewJobGraph("cancelled")
+	for _, name := range []string{"xy", "other"} {
+		j := testJob()
+		j.Name = name
+		if err := g.AddJob(j); err != nil {
+			t.Fatal("Unexpected error", err)
+		}
+	}
+	for _, name := range []string{"xz", "zy"} {
+		j := testJob()
+		j.Name = name
+		if err := g.AddJob(j, "xy"); err != nil {
+			t.Fatal("Unexpected error", err)
+		}
+	}
+	reportDir, err := ioutil.TempDir("", "report")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(reportDir)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	jp := cancellingProcessor{testProcessor: newTestProcessor(), name: "xy", cancel: cancel}
+	err = ProcessJobGraph(ctx, jp, g, config.Config{"maxRunningJobs": 1, "reportDir": reportDir})
+	if !errors.Is(err, context.Canceled) {
+		t.Error("Expected the graph to be cancelled but got", err)
+	}
+	if len(jp.runs) != 1 || jp.runs["xy"] != 1 {
+		t.Error("Expected only the first job to run but got", jp.runs)
+	}
+	summaryJSON, err := ioutil.ReadFile(filepath.Join(reportDir, "cancelled-summary.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var summary runSummary
+	if err = json.Unmarshal(summaryJSON, &summary); err != nil {
+		t.Fatal(err)
+	}
+	if summary.Succeeded != 1 || summary.Skipped != 3 || len(summary.Subjobs) != 4 {
+		t.Errorf("Expected 1 succeeded and 3 skipped jobs but got %d and %d of %d",
+			summary.Succeeded, summary.Skipped, len(summary.Subjobs))
+	}
+}
+
+func TestLocalCmdProcessorEvents(t *testing.T) {
+	var mu sync.Mutex
+	events := make(map[string][]EventType)
+	ctx := WithEventListener(context.Background(), EventListenerFunc(func(e Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		events[e.JobName] = append(events[e.JobName], e.Type)
+	}))
+
+	jp := NewLocalCmdProcessor(config.Config{})
+	succeeded := Job{Executable: "true", Name: "succeeded", CmdlineBuilder: testCmdlineBuilder{}}
+	if err := jp.Run(ctx, succeeded); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	failed := Job{Executable: "false", Name: "failed", CmdlineBuilder: testCmdlineBuilder{}}
+	if err := jp.Run(ctx, failed); err == nil {
+		t.Fatal("Expected the job to fail")
+	}
+	for _, j := range []Job{
+		{Executable: "/nonexistent/executable", Name: "notStarted", CmdlineBuilder: testCmdlineBuilder{}},
+		{Executable: "true", Name: "invalidArgs"},
+	} {
+		if ji, err := jp.Start(ctx, j); err == nil || ji != nil {
+			t.Error("Expected", j.Name, "to fail without a job info but got", ji, err)
+		}
+	}
+
+	expected := map[string][]EventType{
+		"succeeded":   {Submitted, Running, Finished},
+		"failed":      {Submitted, Running, Failed},
+		"notStarted":  {Failed},
+		"invalidArgs": {Failed},
+	}
+	for name, expectedEvents := range expected {
+		if fmt.Sprint(events[name]) != fmt.Sprint(expectedEvents) {
+			t.Error("Expected", expectedEvents, "for", name, "but got", events[name])
+		}
+	}
+}
+
+func TestScriptProcessorWritesAllSubjobs(t *testing.T) {
+	scriptDir, err := ioutil.TempDir("", "script")
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	defer os.RemoveAll(scriptDir)
+
+	resources := config.Config{"maxRunningJobs": 2, "scriptDir": scriptDir, "workingDir": "/tmp/it's here"}
+	sp, err := NewScriptProcessor("plan", resources)
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if err = NewParallelProcessor(sp, testSplitter{3}, resources).Run(context.Background(), testJob()); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if err = sp.(*scriptProcessor).CloseSession(); err != nil {
+		t.Fatal("Unexpected error closing the script", err)
+	}
+
+	jobsJSON, err := ioutil.ReadFile(filepath.Join(scriptDir, "plan-jobs.json"))
+	if err != nil {
+		t.Fatal("Unexpected error reading the job list", err)
+	}
+	var jobs []scriptJob
+	if err = json.Unmarshal(jobsJSON, &jobs); err != nil {
+		t.Fatal("Unexpected error decoding the job list", err, string(jobsJSON))
+	}
+	if len(jobs) != 3 {
+		t.Error("Expected 3 jobs but got", len(jobs))
+	}
+	script, err := ioutil.ReadFile(filepath.Join(scriptDir, "plan.sh"))
+	if err != nil {
+		t.Fatal("Unexpected error reading the script", err)
+	}
+	if !strings.Contains(string(script), `cd '/tmp/it'\''s here'`) || strings.Count(string(script), "'test' '-test'") != 3 {
+		t.Error("Unexpected script", string(script))
+	}
+}
+
+func TestResourcePoolLimitsRunningJobs(t *testing.T) {
+	rp := newResourcePool(4, 0)
+	ctx := context.Background()
+	server, _ := rp.acquire(ctx, "server", ResourceRequirements{})
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if _, err := rp.acquire(timeoutCtx, "client", ResourceRequirements{Slots: 8}); err != context.DeadlineExceeded {
+		t.Fatal("Expected the oversized job to wait until no other job runs but got", err)
+	}
+
+	acquired := make(chan ResourceRequirements)
+	go func() {
+		r, _ := rp.acquire(ctx, "client", ResourceRequirements{Slots: 8})
+		acquired <- r
+	}()
+	// give the oversized job the time to start waiting
+	time.Sleep(50 * time.Millisecond)
+	timeoutCtx, cancel = context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if _, err := rp.acquire(timeoutCtx, "small", ResourceRequirements{Slots: 1}); err != context.DeadlineExceeded {
+		t.Error("Expected the job to wait behind the oversized job but got", err)
+	}
+	rp.release(server)
+	client := <-acquired
+	if client.Slots != 4 {
+		t.Fatal("Expected the oversized job to get the whole pool but got", client)
+	}
+
+	go func() {
+		r, _ := rp.acquire(ctx, "next", ResourceRequirements{Slots: 2})
+		acquired <- r
+	}()
+	select {
+	case r := <-acquired:
+		t.Fatal("Expected the job to wait for free slots but got", r)
+	case <-time.After(50 * time.Millisecond):
+	}
+	rp.release(client)
+	if r := <-acquired; r.Slots != 2 {
+		t.Error("Expected 2 slots but got", r.Slots)
+	}
+}
+
+func TestResourcePoolAdmitsClientsOfLongLivedServer(t *testing.T) {
+	rp := newResourcePool(12, 0)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	server, err := rp.acquire(ctx, "server", ResourceRequirements{Slots: 8, LongLived: true})
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	client, err := rp.acquire(ctx, "client", ResourceRequirements{Slots: 8})
+	if err != nil || client.Slots != 8 {
+		t.Fatal("Expected the client to get its 8 slots next to the server but got", client, err)
+	}
+	rp.release(client)
+	oversized, err := rp.acquire(ctx, "oversizedClient", ResourceRequirements{Slots: 16})
+	if err != nil || oversized.Slots != 12 {
+		t.Fatal("Expected the oversized client to get the whole pool next to the server but got", oversized, err)
+	}
+	rp.release(oversized)
+	rp.release(server)
+	if rp.usedSlots != 0 {
+		t.Error("Expected all slots to be released but", rp.usedSlots, "are still used")
+	}
+
+	os.Setenv(localSlotsEnv, "6")
+	defer os.Unsetenv(localSlotsEnv)
+	if slots, _ := localCapacity(config.Config{"localSlots": 32}); slots != 6 {
+		t.Error("Expected a nested service to use the 6 slots reserved by its parent but got", slots)
+	}
+}
+
+func TestLocalCmdProcessorWritesJobLogs(t *testing.T) {
+	logDir, err := ioutil.TempDir("", "logs")
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	defer os.RemoveAll(logDir)
+
+	jp := NewLocalCmdProcessor(config.Config{"outputDir": logDir})
+	ji, err := jp.Start(context.Background(), Job{Executable: "echo", Name: "echojob", CmdlineBuilder: testCmdlineBuilder{}})
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if err = ji.WaitForTermination(context.Background()); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	pid := ji.(*localCmdInfo).cmd.Process.Pid
+	for _, suffix := range []string{"o", "e"} {
+		if _, err = os.Stat(filepath.Join(logDir, fmt.Sprintf("echojob.%s%d", suffix, pid))); err != nil {
+			t.Error("Expected log file", err)
+		}
+	}
+	stdout, err := ji.JobStdout()
+	if err != nil {
+		t.Fatal("Unexpected error opening the job output", err)
+	}
+	defer stdout.Close()
+	output, _ := ioutil.ReadAll(stdout)
+	if string(output) != "-test\n" {
+		t.Errorf("Unexpected job output %q", output)
+	}
+}
+
+func TestPrefixWriter(t *testing.T) {
+	var console strings.Builder
+	pw := &prefixWriter{w: &console, prefix: []byte("[job] ")}
+	fmt.Fprint(pw, "first line\nsecond ")
+	fmt.Fprint(pw, "line\nlast")
+	pw.flush()
+	if expected := "[job] first line\n[job] second line\n[job] last\n"; console.String() != expected {
+		t.Errorf("Expected %q but got %q", expected, console.String())
+	}
+}
+
+// argsBuilder returns the given command line arguments
+type argsBuilder []string
+
+func (clb argsBuilder) GetCmdlineArgs(a arg.Args) ([]string, error) {
+	return clb, nil
+}
+
+func TestLocalCmdProcessorRuntimeLimit(t *testing.T) {
+	jp := NewLocalCmdProcessor(config.Config{})
+	j := Job{
+		Executable: "sh",
+		Name:       "hung",
+		// the child sleep is in the same process group so it must be killed too
+		CmdlineBuilder: argsBuilder{"-c", "sleep 10 & wait"},
+		Requirements:   ResourceRequirements{RuntimeLimit: 200 * time.Millisecond},
+	}
+	started := time.Now()
+	err := jp.Run(context.Background(), j)
+	var timeoutErr *TimeoutError
+	if !errors.As(err, &timeoutErr) {
+		t.Fatal("Expected a TimeoutError but got", err)
+	}
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Error("The job was not killed after its runtime limit", elapsed)
+	}
+
+	// the limit is enforced even before anybody waits for the job
+	markerDir, err := ioutil.TempDir("", "marker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(markerDir)
+	marker := filepath.Join(markerDir, "completed")
+	slow := Job{
+		Executable:     "sh",
+		Name:           "slow",
+		CmdlineBuilder: argsBuilder{"-c", "sleep 0.5; touch " + marker},
+		Requirements:   ResourceRequirements{RuntimeLimit: 100 * time.Millisecond},
+	}
+	ji, err := jp.Start(context.Background(), slow)
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	time.Sleep(time.Second)
+	if _, err = os.Stat(marker); !os.IsNotExist(err) {
+		t.Error("Expected the job to be killed before anybody waited for it", err)
+	}
+	if err = ji.WaitForTermination(context.Background()); !errors.As(err, &timeoutErr) {
+		t.Fatal("Expected a TimeoutError but got", err)
+	}
+
+	// a job that completed in time does not time out even if it is waited for after its limit
+	quick := Job{
+		Executable:     "true",
+		Name:           "quick",
+		CmdlineBuilder: testCmdlineBuilder{},
+		Requirements:   ResourceRequirements{RuntimeLimit: 100 * time.Millisecond},
+	}
+	if ji, err = jp.Start(context.Background(), quick); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	time.Sleep(300 * time.Millisecond)
+	if err = ji.WaitForTermination(context.Background()); err != nil {
+		t.Error("Expected the job that completed in time to succeed but got", err)
+	}
+}
+
+func TestCancellingTheContextKillsRunningJobs(t *testing.T) {
+	markerDir, err := ioutil.TempDir("", "marker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(markerDir)
+	// every job touches its marker only if it is not killed before it wakes up
+	slowJob := func(name string) Job {
+		return Job{
+			Executable:     "sh",
+			Name:           name,
+			CmdlineBuilder: argsBuilder{"-c", "sleep 1; touch " + filepath.Join(markerDir, "$0"), name},
+		}
+	}
+	local := NewLocalCmdProcessor(config.Config{})
+	parallel := NewParallelProcessor(local, testSplitter{3}, config.Config{"maxRunningJobs": 3})
+	for _, td := range []struct {
+		p Processor
+		j Job
+	}{
+		{local, slowJob("local")},
+		{parallel, slowJob("parallel")},
+	} {
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(200*time.Millisecond, cancel)
+		started := time.Now()
+		if err := td.p.Run(ctx, td.j); !errors.Is(err, context.Canceled) {
+			t.Errorf("%s: expected the context's error but got %v", td.j.Name, err)
+		}
+		if elapsed := time.Since(started); elapsed > 900*time.Millisecond {
+			t.Errorf("%s: Run returned only after %v", td.j.Name, elapsed)
+		}
+	}
+	time.Sleep(1500 * time.Millisecond)
+	markers, _ := filepath.Glob(filepath.Join(markerDir, "*"))
+	if len(markers) != 0 {
+		t.Error("Expected all jobs to be killed but these completed:", markers)
+	}
+}
+
+func TestLocalJobControlWhileWaitingOnPlatformsThatReapOnWait(t *testing.T) {
+	// emulate the platforms that cannot wait for a process without reaping it
+	defer func(wait func(int) bool) { waitUntilExited = wait }(waitUntilExited)
+	waitUntilExited = func(pid int) bool { return false }
+
+	jp := NewLocalCmdProcessor(config.Config{})
+	hung := Job{
+		Executable:     "sh",
+		Name:           "hung",
+		CmdlineBuilder: argsBuilder{"-c", "sleep 10 & wait"},
+		Requirements:   ResourceRequirements{RuntimeLimit: 200 * time.Millisecond},
+	}
+	started := time.Now()
+	var timeoutErr *TimeoutError
+	if err := jp.Run(context.Background(), hung); !errors.As(err, &timeoutErr) {
+		t.Error("Expected a TimeoutError but got", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	hung.Requirements = ResourceRequirements{}
+	if err := jp.Run(ctx, hung); !errors.Is(err, context.DeadlineExceeded) {
+		t.Error("Expected the cancelled job to be killed but got", err)
+	}
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Error("The jobs were not killed while they were waited for", elapsed)
+	}
+
+	ji, err := jp.Start(context.Background(), Job{
+		Executable:     "sh",
+		Name:           "suspended",
+		CmdlineBuilder: argsBuilder{"-c", "sleep 0.3"},
+	})
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- ji.WaitForTermination(context.Background())
+	}()
+	if err = ji.Control(Suspend); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	select {
+	case err = <-done:
+		t.Fatal("Expected the suspended job not to complete but it returned", err)
+	case <-time.After(time.Second):
+	}
+	if err = ji.Control(Resume); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if err = <-done; err != nil {
+		t.Error("Expected the resumed job to succeed but got", err)
+	}
+}
+
+// stagingCmdlineBuilder copies the "in" file to the "out" file and fails unless both are in the "temp" directory
+type stagingCmdlineBuilder struct {
+}
+
+func (clb stagingCmdlineBuilder) GetCmdlineArgs(a arg.Args) ([]string, error) {
+	in, _ := a.GetStringArgValue("in")
+	out, _ := a.GetStringArgValue("out")
+	temp, _ := a.GetStringArgValue("temp")
+	return []string{"-c", `case "$1:$2" in "$3"/*:"$3"/*) cp "$1" "$2" ;; *) exit 2 ;; esac`, "sh", in, out, temp}, nil
+}
+
+func stagingArgs(in, out string) arg.Args {
+	fs := flag.NewFlagSet("staging", flag.ContinueOnError)
+	fs.String("in", in, "")
+	fs.String("out", out, "")
+	fs.String("temp", "", "")
+	return arg.Args{Flags: fs}
+}
+
+func TestLocalCmdProcessorStagesFiles(t *testing.T) {
+	sharedDir, err := ioutil.TempDir("", "shared")
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	defer os.RemoveAll(sharedDir)
+	scratchRoot := filepath.Join(sharedDir, "scratch")
+	input := filepath.Join(sharedDir, "band.pixels.iGrid")
+	if err = ioutil.WriteFile(input, []byte("pixels"), 0664); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	staging := FileStaging{Inputs: []string{"in"}, Outputs: []string{"out"}, ScratchArg: "temp"}
+
+	jp := NewLocalCmdProcessor(config.Config{"scratchDir": scratchRoot})
+	output := filepath.Join(sharedDir, "target", "band.out.iGrid")
+	err = jp.Run(context.Background(), Job{
+		Executable:     "sh",
+		Name:           "staged",
+		JArgs:          stagingArgs(input, output),
+		CmdlineBuilder: stagingCmdlineBuilder{},
+		Staging:        staging,
+	})
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if content, err := ioutil.ReadFile(output); err != nil || string(content) != "pixels" {
+		t.Errorf("Expected the output to be staged out but got %q %v", content, err)
+	}
+
+	missing := filepath.Join(sharedDir, "target", "missing.iGrid")
+	err = jp.Run(context.Background(), Job{
+		Executable:     "sh",
+		Name:           "noOutput",
+		JArgs:          stagingArgs(input, missing),
+		CmdlineBuilder: argsBuilder{"-c", "true"},
+		Staging:        staging,
+	})
+	if err == nil {
+		t.Error("Expected the job to fail because its output could not be staged out")
+	}
+	if _, err = os.Stat(missing); !os.IsNotExist(err) {
+		t.Error("Expected no output but got", err)
+	}
+	if left, _ := ioutil.ReadDir(scratchRoot); len(left) > 0 {
+		t.Errorf("Expected the scratch directories to be removed but found %d", len(left))
+	}
+}
+
+func TestJobStatusBoard(t *testing.T) {
+	board := NewJobStatusBoard()
+	ctx := WithEventListener(context.Background(), board)
+	jp := &retryProcessor{
+		jobProcessor: newTestProcessor("test_1"),
+		maxAttempts:  2,
+		backoff:      time.Millisecond,
+		maxBackoff:   time.Millisecond,
+	}
+	if err := NewParallelProcessor(jp, testSplitter{3}, config.Config{"maxRunningJobs": 3}).Run(ctx, testJob()); err == nil {
+		t.Error("Expected test_1 to fail")
+	}
+
+	r := board.Report()
+	// the parent job and its 3 subjobs
+	if r.Progress.Total != 4 || r.Progress.Finished != 2 || r.Progress.Failed != 2 {
+		t.Error("Unexpected progress", r.Progress)
+	}
+	js, found := board.JobStatus("test_1")
+	if !found || js.State != "Failed" || js.Attempts != 2 || js.Error == "" {
+		t.Error("Unexpected status for test_1", js)
+	}
+}
+
+var registerTestProcessor sync.Once
+
+func TestProcessorRegistry(t *testing.T) {
+	registerTestProcessor.Do(func() {
+		RegisterProcessor("test", func(params ProcessorParams) (Processor, error) {
+			return newTestProcessor(), nil
+		})
+	})
+	if !strings.Contains(ProcessorTypes(), "local, script, test") {
+		t.Error("Expected the test processor to be listed in", ProcessorTypes())
+	}
+	if p, err := NewProcessor("test", ProcessorParams{}); err != nil {
+		t.Error("Unexpected error", err)
+	} else if _, ok := p.(*testProcessor); !ok {
+		t.Errorf("Expected a test processor but got %T", p)
+	}
+	if _, err := NewProcessor("unknown", ProcessorParams{}); err == nil || !strings.Contains(err.Error(), ProcessorTypes()) {
+		t.Error("Expected an error listing the supported processor types but got", err)
+	}
+}
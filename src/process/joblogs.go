@@ -0,0 +1,112 @@
+package process
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+)
+
+// consoleMu serializes the lines that local jobs tee to the console
+var consoleMu sync.Mutex
+
+// jobLog is a local job's output file. Like the grid's log files it is named
+// <jobName>.o<pid> for the standard output and <jobName>.e<pid> for the standard error,
+// but since the process ID is only known after the job started, the file is created
+// with a temporary name and renamed once the job is running.
+type jobLog struct {
+	f       *os.File
+	name    string
+	suffix  string
+	console *prefixWriter
+}
+
+// createJobLog creates the log file in the given directory
+func createJobLog(logDir, jobName, suffix string) (*jobLog, error) {
+	if err := os.MkdirAll(logDir, 0775); err != nil {
+		return nil, fmt.Errorf("Error creating the log directory %s: %v", logDir, err)
+	}
+	f, err := ioutil.TempFile(logDir, jobName+"."+suffix+"*")
+	if err != nil {
+		return nil, fmt.Errorf("Error creating the log file for %s in %s: %v", jobName, logDir, err)
+	}
+	return &jobLog{f: f, name: f.Name(), suffix: suffix}, nil
+}
+
+// tee copies the job's output to the given console prefixing every line with the job's name
+func (jl *jobLog) tee(console io.Writer, jobName string) {
+	jl.console = &prefixWriter{w: console, prefix: []byte("[" + jobName + "] ")}
+}
+
+// writer returns the writer that captures the job's output
+func (jl *jobLog) writer() io.Writer {
+	if jl.console != nil {
+		return io.MultiWriter(jl.f, jl.console)
+	}
+	return jl.f
+}
+
+// rename names the log file after the process ID
+func (jl *jobLog) rename(jobName string, pid int) {
+	name := filepath.Join(filepath.Dir(jl.name), jobName+"."+jl.suffix+strconv.Itoa(pid))
+	if err := os.Rename(jl.name, name); err != nil {
+		log.Printf("Error renaming %s to %s: %v", jl.name, name, err)
+		return
+	}
+	jl.name = name
+}
+
+// open opens the log file for reading
+func (jl *jobLog) open() (io.ReadCloser, error) {
+	return os.Open(jl.name)
+}
+
+func (jl *jobLog) close() error {
+	if jl.console != nil {
+		jl.console.flush()
+	}
+	return jl.f.Close()
+}
+
+// prefixWriter writes every line to the underlying writer preceded by a prefix.
+// Partial lines are buffered until they are complete so that lines from different jobs are not mixed.
+type prefixWriter struct {
+	w      io.Writer
+	prefix []byte
+	buf    []byte
+}
+
+func (pw *prefixWriter) Write(p []byte) (int, error) {
+	pw.buf = append(pw.buf, p...)
+	i := bytes.LastIndexByte(pw.buf, '\n')
+	if i < 0 {
+		return len(p), nil
+	}
+	lines := pw.buf[:i+1]
+	var out bytes.Buffer
+	for len(lines) > 0 {
+		eol := bytes.IndexByte(lines, '\n')
+		out.Write(pw.prefix)
+		out.Write(lines[:eol+1])
+		lines = lines[eol+1:]
+	}
+	pw.buf = append(pw.buf[:0], pw.buf[i+1:]...)
+	consoleMu.Lock()
+	defer consoleMu.Unlock()
+	if _, err := pw.w.Write(out.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// flush writes the last line even if it is incomplete
+func (pw *prefixWriter) flush() {
+	if len(pw.buf) > 0 {
+		pw.Write([]byte("\n"))
+	}
+}
@@ -0,0 +1,140 @@
+package process
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	jobCompleted = "completed"
+	jobFailed    = "failed"
+)
+
+// ResumableSplitter is an optional interface that a Splitter can implement to tell the parallel processor
+// whether the subjobs that completed successfully in a previous run can be skipped when the job is rerun.
+// Splitters that do not implement it are considered resumable.
+type ResumableSplitter interface {
+	Resumable() bool
+}
+
+// journalEntry a journal record for one subjob
+type journalEntry struct {
+	Name        string    `json:"name"`
+	Fingerprint string    `json:"fingerprint"`
+	Outcome     string    `json:"outcome"`
+	Error       string    `json:"error,omitempty"`
+	Time        time.Time `json:"time"`
+}
+
+// jobJournal is an append only file that records the outcome of every subjob processed by a parallel processor
+// so that a rerun of the same job can skip the subjobs that already completed successfully.
+type jobJournal struct {
+	mu        sync.Mutex
+	f         *os.File
+	completed map[string]string // fingerprints of the successfully completed jobs indexed by job name
+}
+
+// openJobJournal opens the journal of the given job from the journal directory
+// and loads the subjobs already completed from a previous run.
+func openJobJournal(journalDir string, j Job) (*jobJournal, error) {
+	if err := os.MkdirAll(journalDir, 0775); err != nil {
+		return nil, fmt.Errorf("Error creating the journal directory %s: %v", journalDir, err)
+	}
+	journalFile := filepath.Join(journalDir, j.Name+".journal")
+	jj := &jobJournal{
+		completed: make(map[string]string),
+	}
+	if err := jj.load(journalFile); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(journalFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0664)
+	if err != nil {
+		return nil, fmt.Errorf("Error opening the journal %s: %v", journalFile, err)
+	}
+	jj.f = f
+	log.Printf("Opened journal %s - %d subjobs already completed", journalFile, len(jj.completed))
+	return jj, nil
+}
+
+func (jj *jobJournal) load(journalFile string) error {
+	f, err := os.Open(journalFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("Error reading the journal %s: %v", journalFile, err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry journalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			// most likely the last record was only partially written when the previous run died
+			log.Printf("Ignore invalid journal record in %s: %v", journalFile, err)
+			continue
+		}
+		if entry.Outcome == jobCompleted {
+			jj.completed[entry.Name] = entry.Fingerprint
+		} else {
+			delete(jj.completed, entry.Name)
+		}
+	}
+	return scanner.Err()
+}
+
+// isCompleted checks if the job with the given fingerprint has already completed successfully
+func (jj *jobJournal) isCompleted(j Job, fingerprint string) bool {
+	jj.mu.Lock()
+	defer jj.mu.Unlock()
+	return fingerprint != "" && jj.completed[j.Name] == fingerprint
+}
+
+// record appends the job's outcome to the journal
+func (jj *jobJournal) record(j Job, fingerprint string, jobErr error) {
+	entry := journalEntry{
+		Name:        j.Name,
+		Fingerprint: fingerprint,
+		Outcome:     jobCompleted,
+		Time:        time.Now(),
+	}
+	if jobErr != nil {
+		entry.Outcome = jobFailed
+		entry.Error = jobErr.Error()
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		log.Printf("Error encoding the journal entry for %s: %v", j.Name, err)
+		return
+	}
+	jj.mu.Lock()
+	defer jj.mu.Unlock()
+	if _, err = jj.f.Write(append(line, '\n')); err != nil {
+		log.Printf("Error writing the journal entry for %s: %v", j.Name, err)
+	}
+}
+
+func (jj *jobJournal) close() error {
+	return jj.f.Close()
+}
+
+// jobFingerprint computes a fingerprint of the job from its executable and its command line arguments
+func jobFingerprint(j Job) (string, error) {
+	cmdargs, err := j.CmdlineArgs()
+	if err != nil {
+		return "", err
+	}
+	h := sha1.New()
+	fmt.Fprintln(h, j.Executable)
+	for _, a := range cmdargs {
+		fmt.Fprintln(h, a)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
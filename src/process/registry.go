@@ -0,0 +1,74 @@
+package process
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"config"
+)
+
+// ProcessorParams the parameters passed to a processor constructor
+type ProcessorParams struct {
+	// AccountID the account billed for the jobs
+	AccountID string
+	// SessionName the name of the processing session
+	SessionName string
+	// Resources the processing configuration
+	Resources config.Config
+}
+
+// ProcessorCtor creates a job processor
+type ProcessorCtor func(params ProcessorParams) (Processor, error)
+
+var (
+	registryMu sync.RWMutex
+	// registry the processor constructors indexed by the processor type
+	registry = make(map[string]ProcessorCtor)
+)
+
+func init() {
+	RegisterProcessor("echo", func(params ProcessorParams) (Processor, error) {
+		return NewEchoProcessor(), nil
+	})
+	RegisterProcessor("local", func(params ProcessorParams) (Processor, error) {
+		return NewLocalCmdProcessor(params.Resources), nil
+	})
+	RegisterProcessor("script", func(params ProcessorParams) (Processor, error) {
+		return NewScriptProcessor(params.SessionName, params.Resources)
+	})
+}
+
+// RegisterProcessor makes a processor type available by name. It panics if the name is already registered.
+func RegisterProcessor(processorType string, ctor ProcessorCtor) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, found := registry[processorType]; found {
+		panic("Processor type " + processorType + " is already registered")
+	}
+	registry[processorType] = ctor
+}
+
+// NewProcessor creates a processor of the given registered type
+func NewProcessor(processorType string, params ProcessorParams) (Processor, error) {
+	registryMu.RLock()
+	ctor, found := registry[processorType]
+	registryMu.RUnlock()
+	if !found {
+		return nil, fmt.Errorf("Invalid processor type: '%s'. Supported types are: %s", processorType, ProcessorTypes())
+	}
+	return ctor(params)
+}
+
+// ProcessorTypes returns the registered processor types formatted as {type1, type2, ...}
+func ProcessorTypes() string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	var processorTypes []string
+	for processorType := range registry {
+		processorTypes = append(processorTypes, processorType)
+	}
+	sort.Strings(processorTypes)
+	return "{" + strings.Join(processorTypes, ", ") + "}"
+}
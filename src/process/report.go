@@ -0,0 +1,158 @@
+package process
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"config"
+)
+
+const (
+	subjobSucceeded = "succeeded"
+	subjobFailed    = "failed"
+	subjobSkipped   = "skipped"
+)
+
+// subjobOutcome the outcome of a single subjob
+type subjobOutcome struct {
+	Name     string    `json:"name"`
+	Status   string    `json:"status"`
+	Error    string    `json:"error,omitempty"`
+	Started  time.Time `json:"started,omitempty"`
+	Finished time.Time `json:"finished,omitempty"`
+}
+
+// runSummary collects the outcomes of all subjobs processed by a parallel processor
+type runSummary struct {
+	mu   sync.Mutex
+	errs JobErrors
+	// skippedJobs the subjobs that the processor skipped even though they were handed to it
+	skippedJobs map[string]bool
+	Job         string          `json:"job"`
+	Started     time.Time       `json:"started"`
+	Finished    time.Time       `json:"finished"`
+	Succeeded   int             `json:"succeeded"`
+	Failed      int             `json:"failed"`
+	Skipped     int             `json:"skipped"`
+	Subjobs     []subjobOutcome `json:"subjobs"`
+}
+
+// ReportDir returns the directory where run reports are written: "reportDir" if set, otherwise "outputDir"
+func ReportDir(resources config.Config) string {
+	if reportDir := resources.GetStringProperty("reportDir"); reportDir != "" {
+		return reportDir
+	}
+	return resources.GetStringProperty("outputDir")
+}
+
+func newRunSummary(j Job) *runSummary {
+	return &runSummary{
+		Job:     j.Name,
+		Started: time.Now(),
+	}
+}
+
+// completed records the outcome of a subjob that was run
+func (rs *runSummary) completed(j Job, jobErr error, started time.Time) {
+	outcome := subjobOutcome{
+		Name:     j.Name,
+		Status:   subjobSucceeded,
+		Started:  started,
+		Finished: time.Now(),
+	}
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if jobErr == nil && rs.skippedJobs[j.Name] {
+		// the processor already reported it as skipped
+		return
+	}
+	if jobErr != nil {
+		outcome.Status = subjobFailed
+		outcome.Error = jobErr.Error()
+		rs.errs = append(rs.errs, &JobError{Name: j.Name, Err: jobErr})
+		rs.Failed++
+	} else {
+		rs.Succeeded++
+	}
+	rs.Subjobs = append(rs.Subjobs, outcome)
+}
+
+// skipped records a subjob that was not run and the reason it was skipped, if any.
+// Skipped subjobs are not failures so they are not included in the returned errors.
+func (rs *runSummary) skipped(j Job, reason error) {
+	outcome := subjobOutcome{
+		Name:   j.Name,
+		Status: subjobSkipped,
+	}
+	if reason != nil {
+		outcome.Error = reason.Error()
+	}
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if rs.skippedJobs == nil {
+		rs.skippedJobs = make(map[string]bool)
+	}
+	rs.skippedJobs[j.Name] = true
+	rs.Skipped++
+	rs.Subjobs = append(rs.Subjobs, outcome)
+}
+
+type runSummaryKey struct{}
+
+// withRunSummary returns a context through which the processors of the subjobs can report the subjobs they skip
+func withRunSummary(ctx context.Context, rs *runSummary) context.Context {
+	return context.WithValue(ctx, runSummaryKey{}, rs)
+}
+
+// reportSkipped records the job as skipped in the run summary of the parallel job it belongs to, if any
+func reportSkipped(ctx context.Context, j Job) {
+	if rs, ok := ctx.Value(runSummaryKey{}).(*runSummary); ok {
+		rs.skipped(j, nil)
+	}
+}
+
+// failed records an error that is not a subjob's error, e.g., a splitter error
+func (rs *runSummary) failed(name string, err error) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.errs = append(rs.errs, &JobError{Name: name, Err: err})
+}
+
+// finish marks the end of the run and returns the errors of all failed subjobs, or nil if all succeeded
+func (rs *runSummary) finish() error {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.Finished = time.Now()
+	log.Printf("Job %s completed in %v: %d succeeded, %d failed, %d skipped",
+		rs.Job, rs.Finished.Sub(rs.Started), rs.Succeeded, rs.Failed, rs.Skipped)
+	if len(rs.errs) == 0 {
+		return nil
+	}
+	return rs.errs
+}
+
+// write saves the summary as JSON in the given directory
+func (rs *runSummary) write(reportDir string) error {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if err := os.MkdirAll(reportDir, 0775); err != nil {
+		return fmt.Errorf("Error creating the report directory %s: %v", reportDir, err)
+	}
+	summaryJSON, err := json.MarshalIndent(rs, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Error encoding the summary for %s: %v", rs.Job, err)
+	}
+	summaryFile := filepath.Join(reportDir, rs.Job+"-summary.json")
+	if err = ioutil.WriteFile(summaryFile, summaryJSON, 0664); err != nil {
+		return fmt.Errorf("Error writing the summary %s: %v", summaryFile, err)
+	}
+	log.Printf("Wrote job summary %s", summaryFile)
+	return nil
+}
@@ -0,0 +1,204 @@
+package process
+
+import (
+	"context"
+	"log"
+	"os"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+
+	"config"
+)
+
+// ResourceRequirements the resources that a job needs while it runs
+type ResourceRequirements struct {
+	// Slots number of CPU slots, e.g., the number of threads the job uses
+	Slots int
+	// MemoryMB estimated memory in MB
+	MemoryMB int
+	// RuntimeLimit maximum wall-clock time the job may run; 0 means no limit
+	RuntimeLimit time.Duration
+	// Queue grid queue the job is submitted to
+	Queue string
+	// NativeSpec additional scheduler specific submit options, e.g., "-l sandy=true"
+	NativeSpec string
+	// Role identifies the kind of job, e.g., "dmgServer" or "retile"; the requirements
+	// that the job does not set are read from the role's entry in "jobProfiles"
+	Role string
+	// LongLived marks a job that stays up, mostly idle, until the jobs it serves complete, e.g., the DMG server.
+	// Such a job is not counted against the local machine's capacity, which is left to the jobs it waits for.
+	LongLived bool
+}
+
+// JobRequirements returns the job's requirements completed from the profile of the job's role. A profile is
+// an entry in "jobProfiles" keyed by the role name, e.g., {"dmgServer": {"slots": 1, "memory": 2048,
+// "queue": "short", "runtimeLimit": 3600, "nativeSpec": "-l sandy=true"}} where the memory is in MB
+// and the runtime limit is in seconds. A profile may also mark the role's jobs as long lived with "longLived": true.
+func JobRequirements(j Job, resources config.Config) ResourceRequirements {
+	r := j.Requirements
+	if r.Role == "" {
+		return r
+	}
+	profile := resources.GetConfigProperty("jobProfiles").GetConfigProperty(r.Role)
+	if r.Slots <= 0 {
+		r.Slots = profile.GetIntProperty("slots")
+	}
+	if r.MemoryMB <= 0 {
+		r.MemoryMB = profile.GetIntProperty("memory")
+	}
+	if r.RuntimeLimit <= 0 {
+		r.RuntimeLimit = time.Duration(profile.GetInt64Property("runtimeLimit")) * time.Second
+	}
+	if r.Queue == "" {
+		r.Queue = profile.GetStringProperty("queue")
+	}
+	if r.NativeSpec == "" {
+		r.NativeSpec = profile.GetStringProperty("nativeSpec")
+	}
+	if !r.LongLived {
+		r.LongLived = profile.GetBoolProperty("longLived")
+	}
+	return r
+}
+
+// resourcePool keeps track of the resources available for running local jobs
+type resourcePool struct {
+	mu           sync.Mutex
+	slots        int
+	memoryMB     int // 0 if memory is not limited
+	usedSlots    int
+	usedMemoryMB int
+	// waitingWholePool the number of jobs waiting for the whole pool; no other job is admitted while they wait
+	waitingWholePool int
+	released         chan struct{} // closed every time resources are released
+}
+
+const (
+	// localSlotsEnv and localMemoryEnv pass the resources reserved for a local job to the job's process,
+	// so that a nested service, e.g., a dmgservice run for a DMG section, runs its own jobs within them
+	localSlotsEnv  = "DMGWRAPPER_LOCAL_SLOTS"
+	localMemoryEnv = "DMGWRAPPER_LOCAL_MEMORY"
+)
+
+var (
+	localPoolOnce sync.Once
+	localPool     *resourcePool
+)
+
+// localResourcePool returns the pool shared by all local processors
+func localResourcePool(resources config.Config) *resourcePool {
+	localPoolOnce.Do(func() {
+		localPool = newResourcePool(localCapacity(resources))
+	})
+	return localPool
+}
+
+// localCapacity returns the slots and the memory in MB of the local machine. If the process was started as a local
+// job by another process the capacity is what the parent reserved for the job. Otherwise it is read from "localSlots",
+// which defaults to the number of CPUs, and "localMemory", which defaults to 0, i.e., the memory is not limited.
+func localCapacity(resources config.Config) (slots, memoryMB int) {
+	slots = resources.GetIntProperty("localSlots")
+	memoryMB = resources.GetIntProperty("localMemory")
+	if reservedSlots, err := strconv.Atoi(os.Getenv(localSlotsEnv)); err == nil && reservedSlots > 0 {
+		slots = reservedSlots
+	}
+	if reservedMemory, err := strconv.Atoi(os.Getenv(localMemoryEnv)); err == nil && reservedMemory > 0 {
+		memoryMB = reservedMemory
+	}
+	if slots <= 0 {
+		slots = runtime.NumCPU()
+	}
+	return slots, memoryMB
+}
+
+// localJobEnv returns the environment of a local job that holds the reserved resources
+func localJobEnv(reserved ResourceRequirements) []string {
+	env := os.Environ()
+	if reserved.Slots > 0 {
+		env = append(env, localSlotsEnv+"="+strconv.Itoa(reserved.Slots))
+	}
+	if reserved.MemoryMB > 0 {
+		env = append(env, localMemoryEnv+"="+strconv.Itoa(reserved.MemoryMB))
+	}
+	return env
+}
+
+func newResourcePool(slots, memoryMB int) *resourcePool {
+	return &resourcePool{
+		slots:    slots,
+		memoryMB: memoryMB,
+		released: make(chan struct{}),
+	}
+}
+
+// acquire blocks until the requested resources are available or the context is cancelled
+// and returns the resources that were actually reserved. A request for at least as many slots as
+// the pool has waits until no other job runs and then gets the whole pool; while it waits no other job
+// is admitted so that it cannot be starved by smaller jobs. Long lived jobs, e.g., the DMG server waiting for
+// its clients, are admitted right away and reserve nothing so that their clients can always use the whole pool.
+func (rp *resourcePool) acquire(ctx context.Context, jobName string, r ResourceRequirements) (ResourceRequirements, error) {
+	if r.LongLived {
+		return ResourceRequirements{LongLived: true}, nil
+	}
+	if r.Slots <= 0 {
+		r.Slots = 1
+	}
+	wholePool := r.Slots >= rp.slots
+	if r.Slots > rp.slots {
+		log.Printf("Job %s requires %d slots but the machine only has %d", jobName, r.Slots, rp.slots)
+	}
+	if wholePool {
+		r.Slots = rp.slots
+	}
+	if rp.memoryMB > 0 && r.MemoryMB > rp.memoryMB {
+		log.Printf("Job %s requires %dMB but the machine only has %dMB", jobName, r.MemoryMB, rp.memoryMB)
+		r.MemoryMB = rp.memoryMB
+	}
+	rp.mu.Lock()
+	if wholePool {
+		rp.waitingWholePool++
+	}
+	for {
+		admitted := wholePool || rp.waitingWholePool == 0
+		if admitted && rp.usedSlots+r.Slots <= rp.slots && (rp.memoryMB <= 0 || rp.usedMemoryMB+r.MemoryMB <= rp.memoryMB) {
+			if wholePool {
+				rp.waitingWholePool--
+			}
+			rp.usedSlots += r.Slots
+			rp.usedMemoryMB += r.MemoryMB
+			rp.mu.Unlock()
+			return r, nil
+		}
+		released := rp.released
+		rp.mu.Unlock()
+		select {
+		case <-released:
+			rp.mu.Lock()
+		case <-ctx.Done():
+			if wholePool {
+				rp.mu.Lock()
+				rp.waitingWholePool--
+				rp.notify()
+				rp.mu.Unlock()
+			}
+			return ResourceRequirements{}, ctx.Err()
+		}
+	}
+}
+
+// release gives back resources reserved with acquire
+func (rp *resourcePool) release(r ResourceRequirements) {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	rp.usedSlots -= r.Slots
+	rp.usedMemoryMB -= r.MemoryMB
+	rp.notify()
+}
+
+// notify wakes up the jobs waiting for resources; it must be called with the lock held
+func (rp *resourcePool) notify() {
+	close(rp.released)
+	rp.released = make(chan struct{})
+}
@@ -0,0 +1,147 @@
+package process
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"time"
+
+	"config"
+)
+
+const (
+	defaultRetryBackoffInSec    = 30
+	defaultRetryMaxBackoffInSec = 600
+	defaultRetryJitter          = 0.2
+)
+
+// ProcessorDecorator adds a behavior to a processor
+type ProcessorDecorator func(p Processor) Processor
+
+// DecorateProcessor - decorate a processor
+func DecorateProcessor(p Processor, decorators ...ProcessorDecorator) Processor {
+	decorated := p
+	for _, decorate := range decorators {
+		decorated = decorate(decorated)
+	}
+	return decorated
+}
+
+// retryProcessor resubmits the jobs that failed with a retriable error
+type retryProcessor struct {
+	JobWatcher
+	jobProcessor Processor
+	maxAttempts  int
+	backoff      time.Duration
+	maxBackoff   time.Duration
+	jitter       float64
+}
+
+// RetryProcessing - decorator that resubmits a failed job until it succeeds or it reaches the maximum
+// number of attempts ("maxJobAttempts"). Between attempts it waits for an exponentially increasing
+// interval starting at "retryBackoff" seconds, capped at "retryMaxBackoff" seconds and randomized
+// by a "retryJitter" fraction. Only the jobs that failed with a retriable error, e.g., a timeout
+// or a grid failure, are resubmitted; jobs that can never succeed, e.g., because of invalid arguments,
+// are not (see IsRetriable).
+func RetryProcessing(resources config.Config) ProcessorDecorator {
+	return func(p Processor) Processor {
+		maxAttempts := resources.GetIntProperty("maxJobAttempts")
+		if maxAttempts <= 1 {
+			return p
+		}
+		backoff := resources.GetInt64Property("retryBackoff")
+		if backoff <= 0 {
+			backoff = defaultRetryBackoffInSec
+		}
+		maxBackoff := resources.GetInt64Property("retryMaxBackoff")
+		if maxBackoff <= 0 {
+			maxBackoff = defaultRetryMaxBackoffInSec
+		}
+		jitter := defaultRetryJitter
+		if _, ok := resources["retryJitter"]; ok {
+			jitter = resources.GetFloat64Property("retryJitter")
+		}
+		return &retryProcessor{
+			jobProcessor: p,
+			maxAttempts:  maxAttempts,
+			backoff:      time.Duration(backoff) * time.Second,
+			maxBackoff:   time.Duration(maxBackoff) * time.Second,
+			jitter:       jitter,
+		}
+	}
+}
+
+// Run the given job and resubmit it if it fails
+func (p *retryProcessor) Run(ctx context.Context, j Job) error {
+	return p.retry(ctx, j, func() error {
+		return p.jobProcessor.Run(ctx, j)
+	})
+}
+
+// Start the given job. Only the failures to start the job are retried; use Run
+// to resubmit jobs that fail after they started.
+func (p *retryProcessor) Start(ctx context.Context, j Job) (ji Info, err error) {
+	err = p.retry(ctx, j, func() error {
+		ji, err = p.jobProcessor.Start(ctx, j)
+		return err
+	})
+	return ji, err
+}
+
+// StartBulk submits the jobs with the decorated processor. Like for Start only the failures
+// to submit the jobs are retried.
+func (p *retryProcessor) StartBulk(ctx context.Context, name string, jobs []Job) (infos []Info, err error) {
+	bp, ok := asBulkProcessor(p.jobProcessor)
+	if !ok {
+		return nil, fmt.Errorf("Jobs of %s cannot be submitted in bulk", name)
+	}
+	err = p.retry(ctx, Job{Name: name}, func() error {
+		infos, err = bp.StartBulk(ctx, name, jobs)
+		return err
+	})
+	return infos, err
+}
+
+func (p *retryProcessor) decorated() Processor {
+	return p.jobProcessor
+}
+
+func (p *retryProcessor) retry(ctx context.Context, j Job, attempt func() error) error {
+	var err error
+	for a := 1; ; a++ {
+		if err = attempt(); err == nil {
+			return nil
+		}
+		if !IsRetriable(err) {
+			log.Printf("Job %s failed with an error that is not retriable: %v", j.Name, err)
+			return err
+		}
+		if a >= p.maxAttempts {
+			break
+		}
+		delay := p.delay(a)
+		log.Printf("Retry (%d) processing for %s in %v after: %v", a, j.Name, delay, err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return fmt.Errorf("Processing of %s aborted after %d attempts: %w", j.Name, p.maxAttempts, err)
+}
+
+// delay returns the wait interval before the next attempt
+func (p *retryProcessor) delay(attempt int) time.Duration {
+	d := p.backoff
+	for i := 1; i < attempt && d < p.maxBackoff; i++ {
+		d *= 2
+	}
+	if d > p.maxBackoff {
+		d = p.maxBackoff
+	}
+	if p.jitter > 0 {
+		d += time.Duration(p.jitter * (2*rand.Float64() - 1) * float64(d))
+	}
+	return d
+}
@@ -0,0 +1,252 @@
+package process
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"config"
+)
+
+// jobListEnd terminates the JSON job list; it is overwritten every time a new job is appended
+const jobListEnd = "\n]\n"
+
+// scriptJob a job entry from the JSON job list
+type scriptJob struct {
+	Name        string            `json:"name"`
+	Executable  string            `json:"executable"`
+	Args        []string          `json:"args"`
+	WorkingDir  string            `json:"workingDir"`
+	Environment map[string]string `json:"environment,omitempty"`
+	LongLived   bool              `json:"longLived,omitempty"`
+}
+
+// ErrScriptedJob is returned for the output of a job that was only written to the script
+var ErrScriptedJob = errors.New("the job was only written to the script")
+
+// scriptJobInfo info of a job that was only written to the script
+type scriptJobInfo struct {
+	name string
+}
+
+// JobStdout returns an error since the job does not run
+func (sji scriptJobInfo) JobStdout() (io.ReadCloser, error) {
+	return nil, fmt.Errorf("Job %s: %w", sji.name, ErrScriptedJob)
+}
+
+// JobStderr returns an error since the job does not run
+func (sji scriptJobInfo) JobStderr() (io.ReadCloser, error) {
+	return nil, fmt.Errorf("Job %s: %w", sji.name, ErrScriptedJob)
+}
+
+// WaitForTermination returns immediately since the job does not run
+func (sji scriptJobInfo) WaitForTermination(ctx context.Context) error {
+	return nil
+}
+
+// Control returns an error since the job does not run
+func (sji scriptJobInfo) Control(action JobAction) error {
+	return &UnsupportedActionError{Name: sji.name, Action: action}
+}
+
+// scriptProcessor is a processor that does not run the jobs but it appends them to a bash script
+// and to a JSON job list so that the entire plan can be reviewed or run by hand.
+type scriptProcessor struct {
+	JobWatcher
+	name       string
+	resources  config.Config
+	mu         sync.Mutex
+	scriptFile *os.File
+	jobsFile   *os.File
+	nJobs      int
+	nLongLived int
+}
+
+var (
+	scriptProcessorsMu sync.Mutex
+	// scriptProcessors the open script processors indexed by name
+	scriptProcessors = make(map[string]*scriptProcessor)
+)
+
+// NewScriptProcessor creates a processor that writes every job it receives to <name>.sh and
+// to <name>-jobs.json in the "scriptDir" directory (the current directory if not set).
+// All processors created with the same name share the same script until the session is closed.
+func NewScriptProcessor(name string, resources config.Config) (Processor, error) {
+	scriptProcessorsMu.Lock()
+	defer scriptProcessorsMu.Unlock()
+	if p, found := scriptProcessors[name]; found {
+		return p, nil
+	}
+	scriptDir := resources.GetStringProperty("scriptDir")
+	if scriptDir == "" {
+		scriptDir = "."
+	}
+	if err := os.MkdirAll(scriptDir, 0775); err != nil {
+		return nil, fmt.Errorf("Error creating the script directory %s: %v", scriptDir, err)
+	}
+	scriptName := filepath.Join(scriptDir, name+".sh")
+	scriptFile, err := os.OpenFile(scriptName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0775)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating the script %s: %v", scriptName, err)
+	}
+	jobsName := filepath.Join(scriptDir, name+"-jobs.json")
+	jobsFile, err := os.Create(jobsName)
+	if err != nil {
+		scriptFile.Close()
+		return nil, fmt.Errorf("Error creating the job list %s: %v", jobsName, err)
+	}
+	p := &scriptProcessor{
+		name:       name,
+		resources:  resources,
+		scriptFile: scriptFile,
+		jobsFile:   jobsFile,
+	}
+	if _, err = fmt.Fprintf(scriptFile, "#!/bin/bash\n\nset -e\n"); err == nil {
+		_, err = jobsFile.WriteString("[" + jobListEnd)
+	}
+	if err != nil {
+		p.close()
+		return nil, fmt.Errorf("Error initializing the script %s: %v", scriptName, err)
+	}
+	log.Printf("Write jobs to %s and %s", scriptName, jobsName)
+	scriptProcessors[name] = p
+	return p, nil
+}
+
+// Run the given job
+func (p *scriptProcessor) Run(ctx context.Context, j Job) error {
+	ji, err := p.Start(ctx, j)
+	if err != nil {
+		return fmt.Errorf("Error starting %v: %w", j, err)
+	}
+	return p.Wait(ctx, ji)
+}
+
+// Start appends the job to the script and to the job list
+func (p *scriptProcessor) Start(ctx context.Context, j Job) (Info, error) {
+	cmdargs, err := j.CmdlineArgs()
+	if err != nil {
+		return nil, err
+	}
+	sj := scriptJob{
+		Name:        j.Name,
+		Executable:  j.Executable,
+		Args:        cmdargs,
+		Environment: p.resources.GetStringMapProperty("ugeJobEnvironment"),
+		LongLived:   JobRequirements(j, p.resources).LongLived,
+	}
+	if sj.WorkingDir = p.resources.GetStringProperty("workingDir"); sj.WorkingDir == "" {
+		if sj.WorkingDir, err = os.Getwd(); err != nil {
+			log.Printf("Error retrieving the working directory: %v", err)
+			sj.WorkingDir = "."
+		}
+	}
+	tracker := NewJobTracker(ctx, "script", j.Name)
+	tracker.Submitted("")
+	if err = p.write(sj); err != nil {
+		tracker.Done(err)
+		return nil, err
+	}
+	tracker.Done(nil)
+	return scriptJobInfo{j.Name}, nil
+}
+
+func (p *scriptProcessor) write(sj scriptJob) error {
+	sjJSON, err := json.MarshalIndent(sj, "  ", "  ")
+	if err != nil {
+		return fmt.Errorf("Error encoding job %s: %v", sj.Name, err)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	cmd := scriptCommand(sj)
+	if sj.LongLived && p.nLongLived == 0 {
+		// do not leave the long lived jobs behind if the script fails
+		cmd = "\ntrap 'kill \"${longLivedJobs[@]}\" 2>/dev/null || true' EXIT\n" + cmd
+	}
+	if _, err = p.scriptFile.WriteString(cmd); err != nil {
+		return fmt.Errorf("Error writing job %s to %s: %v", sj.Name, p.scriptFile.Name(), err)
+	}
+	// overwrite the end of the list with the new entry
+	if _, err = p.jobsFile.Seek(-int64(len(jobListEnd)), io.SeekCurrent); err != nil {
+		return fmt.Errorf("Error writing job %s to %s: %v", sj.Name, p.jobsFile.Name(), err)
+	}
+	separator := "\n  "
+	if p.nJobs > 0 {
+		separator = ",\n  "
+	}
+	if _, err = p.jobsFile.WriteString(separator + string(sjJSON) + jobListEnd); err != nil {
+		return fmt.Errorf("Error writing job %s to %s: %v", sj.Name, p.jobsFile.Name(), err)
+	}
+	p.nJobs++
+	if sj.LongLived {
+		p.nLongLived++
+	}
+	return nil
+}
+
+// scriptCommand generates the bash commands that run the job in a subshell
+func scriptCommand(sj scriptJob) string {
+	var cmd strings.Builder
+	fmt.Fprintf(&cmd, "\n# %s\n(\n  cd %s\n", sj.Name, ShellQuote(sj.WorkingDir))
+	envNames := make([]string, 0, len(sj.Environment))
+	for name := range sj.Environment {
+		envNames = append(envNames, name)
+	}
+	sort.Strings(envNames)
+	for _, name := range envNames {
+		fmt.Fprintf(&cmd, "  export %s=%s\n", name, ShellQuote(sj.Environment[name]))
+	}
+	cmd.WriteString("  " + ShellQuote(sj.Executable))
+	for _, a := range sj.Args {
+		cmd.WriteString(" " + ShellQuote(a))
+	}
+	if sj.LongLived {
+		// keep it running in the background and wait for it at the end of the script
+		cmd.WriteString("\n) &\nlongLivedJobs+=($!)\n")
+	} else {
+		cmd.WriteString("\n)\n")
+	}
+	return cmd.String()
+}
+
+// ShellQuote quotes the value so that the shell interprets it literally
+func ShellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// CloseSession closes the script and the job list
+func (p *scriptProcessor) CloseSession() error {
+	scriptProcessorsMu.Lock()
+	defer scriptProcessorsMu.Unlock()
+	if scriptProcessors[p.name] != p {
+		// already closed
+		return nil
+	}
+	delete(scriptProcessors, p.name)
+	return p.close()
+}
+
+func (p *scriptProcessor) close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	log.Printf("Wrote %d jobs to %s", p.nJobs, p.scriptFile.Name())
+	var scriptErr error
+	if p.nLongLived > 0 {
+		_, scriptErr = p.scriptFile.WriteString("\nfor pid in \"${longLivedJobs[@]}\"; do\n  wait \"$pid\"\ndone\n")
+	}
+	if closeErr := p.scriptFile.Close(); scriptErr == nil {
+		scriptErr = closeErr
+	}
+	if jobsErr := p.jobsFile.Close(); jobsErr != nil {
+		return jobsErr
+	}
+	return scriptErr
+}
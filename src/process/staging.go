@@ -0,0 +1,220 @@
+package process
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// FileStaging declares the files a job reads and writes through a node-local scratch directory
+// so that the job reads and writes them on the node's local disk instead of the shared file system
+// the files live on. Unless the processor transfers the files itself, e.g., through DRMAA2,
+// the files are copied on the node that runs the job so the node must still mount the shared file system.
+// The files are referenced by the names of the job's string arguments that hold their paths.
+type FileStaging struct {
+	// Inputs the arguments of the files copied to the scratch directory before the job runs
+	Inputs []string
+	// Outputs the arguments of the files moved from the scratch directory to their
+	// location on the shared file system after the job succeeds
+	Outputs []string
+	// ScratchArg the argument that is set to the job's scratch directory, e.g., the job's temporary directory
+	ScratchArg string
+}
+
+// Declared returns true if the job stages any file
+func (s FileStaging) Declared() bool {
+	return len(s.Inputs) > 0 || len(s.Outputs) > 0
+}
+
+// StagedFile a file copied between its shared location and the job's scratch directory
+type StagedFile struct {
+	// Path the file's location on the shared file system
+	Path string
+	// ScratchName the file's name in the job's scratch directory
+	ScratchName string
+}
+
+// StagingPlan the files staged in and out of a job's scratch directory
+type StagingPlan struct {
+	Inputs  []StagedFile
+	Outputs []StagedFile
+}
+
+// StageJob returns the job's staging plan and a copy of the job whose staged arguments point to
+// the given scratch directory. Arguments with no value are not staged. If the job does not declare
+// any staged file it returns the job unchanged and a nil plan.
+func StageJob(j Job, scratchDir string) (Job, *StagingPlan, error) {
+	if !j.Staging.Declared() {
+		return j, nil, nil
+	}
+	stagedArgs := j.JArgs.Clone()
+	plan := &StagingPlan{}
+	stage := func(argNames []string, prefix string) ([]StagedFile, error) {
+		var files []StagedFile
+		for _, name := range argNames {
+			path, err := j.JArgs.GetStringArgValue(name)
+			if err != nil {
+				return nil, &InvalidArgsError{Name: j.Name, Err: err}
+			}
+			if path == "" {
+				continue
+			}
+			// the index keeps apart files with the same name from different directories
+			f := StagedFile{
+				Path:        path,
+				ScratchName: fmt.Sprintf("%s%d-%s", prefix, len(files), filepath.Base(path)),
+			}
+			files = append(files, f)
+			stagedArgs.UpdateStringArg(name, filepath.Join(scratchDir, f.ScratchName))
+		}
+		return files, nil
+	}
+	var err error
+	if plan.Inputs, err = stage(j.Staging.Inputs, "in"); err != nil {
+		return j, nil, err
+	}
+	if plan.Outputs, err = stage(j.Staging.Outputs, "out"); err != nil {
+		return j, nil, err
+	}
+	if j.Staging.ScratchArg != "" {
+		stagedArgs.UpdateStringArg(j.Staging.ScratchArg, scratchDir)
+	}
+	j.JArgs = stagedArgs
+	return j, plan, nil
+}
+
+// StageIn copies the input files to the scratch directory
+func (sp *StagingPlan) StageIn(scratchDir string) error {
+	for _, f := range sp.Inputs {
+		if err := copyVerified(f.Path, filepath.Join(scratchDir, f.ScratchName)); err != nil {
+			return fmt.Errorf("Error staging in %s: %w", f.Path, err)
+		}
+	}
+	return nil
+}
+
+// StageOut moves the output files from the scratch directory to their location on the shared file system.
+// Every output is first copied next to its destination and then renamed so that an interrupted
+// stage out never leaves a partial output in place.
+func (sp *StagingPlan) StageOut(scratchDir string) error {
+	for _, f := range sp.Outputs {
+		scratchFile := filepath.Join(scratchDir, f.ScratchName)
+		if err := os.MkdirAll(filepath.Dir(f.Path), 0775); err != nil {
+			return fmt.Errorf("Error creating the output directory for %s: %v", f.Path, err)
+		}
+		stagingFile := f.Path + ".staging"
+		if err := copyVerified(scratchFile, stagingFile); err != nil {
+			os.Remove(stagingFile)
+			return fmt.Errorf("Error staging out %s: %w", f.Path, err)
+		}
+		if err := os.Rename(stagingFile, f.Path); err != nil {
+			os.Remove(stagingFile)
+			return fmt.Errorf("Error staging out %s: %v", f.Path, err)
+		}
+		if err := os.Remove(scratchFile); err != nil {
+			log.Printf("Error removing the staged output %s: %v", scratchFile, err)
+		}
+	}
+	return nil
+}
+
+// copyVerified copies the file and checks that the copy has the same size as the source
+func copyVerified(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	srcInfo, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	if !srcInfo.Mode().IsRegular() {
+		return fmt.Errorf("%s is not a regular file", src)
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, srcInfo.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	dstInfo, err := os.Stat(dst)
+	if err != nil {
+		return err
+	}
+	if dstInfo.Size() != srcInfo.Size() {
+		return fmt.Errorf("%s has %d bytes but its copy %s has %d", src, srcInfo.Size(), dst, dstInfo.Size())
+	}
+	return nil
+}
+
+// createScratchDir creates the job's scratch directory in the node-local scratchRoot
+func createScratchDir(scratchRoot, jobName string) (string, error) {
+	if err := os.MkdirAll(scratchRoot, 0775); err != nil {
+		return "", fmt.Errorf("Error creating the scratch directory %s: %v", scratchRoot, err)
+	}
+	scratchDir, err := ioutil.TempDir(scratchRoot, jobName+".")
+	if err != nil {
+		return "", fmt.Errorf("Error creating the scratch directory for %s in %s: %v", jobName, scratchRoot, err)
+	}
+	return scratchDir, nil
+}
+
+// jobScratch the scratch directory of a job that runs on the local machine. A nil jobScratch does not stage anything.
+type jobScratch struct {
+	dir  string
+	plan *StagingPlan
+}
+
+// stageJobIn creates the job's directory in scratchRoot, copies the job's inputs there and returns
+// the job whose staged arguments point to the scratch directory
+func stageJobIn(j Job, scratchRoot string) (Job, *jobScratch, error) {
+	if scratchRoot == "" || !j.Staging.Declared() {
+		return j, nil, nil
+	}
+	scratchDir, err := createScratchDir(scratchRoot, j.Name)
+	if err != nil {
+		return j, nil, err
+	}
+	s := &jobScratch{dir: scratchDir}
+	stagedJob, plan, err := StageJob(j, scratchDir)
+	if err != nil {
+		s.remove()
+		return j, nil, err
+	}
+	s.plan = plan
+	if err = plan.StageIn(scratchDir); err != nil {
+		s.remove()
+		return j, nil, err
+	}
+	return stagedJob, s, nil
+}
+
+// stageOut moves the job's outputs back to the shared file system
+func (s *jobScratch) stageOut() error {
+	if s == nil {
+		return nil
+	}
+	return s.plan.StageOut(s.dir)
+}
+
+// remove removes the scratch directory together with everything the job left in it
+func (s *jobScratch) remove() {
+	if s == nil {
+		return
+	}
+	if err := os.RemoveAll(s.dir); err != nil {
+		log.Printf("Error removing the scratch directory %s: %v", s.dir, err)
+	}
+}
@@ -0,0 +1,137 @@
+package process
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// JobStatus the last known status of a job
+type JobStatus struct {
+	Name      string     `json:"name"`
+	Processor string     `json:"processor"`
+	ID        string     `json:"id,omitempty"`
+	Host      string     `json:"host,omitempty"`
+	State     string     `json:"state"`
+	Attempts  int        `json:"attempts"`
+	Submitted time.Time  `json:"submitted"`
+	Started   *time.Time `json:"started,omitempty"`
+	Finished  *time.Time `json:"finished,omitempty"`
+	Error     string     `json:"error,omitempty"`
+}
+
+// JobProgress counts the jobs by their state
+type JobProgress struct {
+	Total    int `json:"total"`
+	Queued   int `json:"queued"`
+	Running  int `json:"running"`
+	Finished int `json:"finished"`
+	Failed   int `json:"failed"`
+	Skipped  int `json:"skipped"`
+}
+
+// JobStatusReport the status of all known jobs
+type JobStatusReport struct {
+	Started  time.Time   `json:"started"`
+	Progress JobProgress `json:"progress"`
+	Jobs     []JobStatus `json:"jobs"`
+}
+
+// JobStatusBoard keeps the status of every job from the events it receives
+type JobStatusBoard struct {
+	mu      sync.Mutex
+	started time.Time
+	jobs    map[string]*JobStatus
+}
+
+// NewJobStatusBoard creates an empty status board
+func NewJobStatusBoard() *JobStatusBoard {
+	return &JobStatusBoard{
+		started: time.Now(),
+		jobs:    make(map[string]*JobStatus),
+	}
+}
+
+// JobEvent updates the status of the job from the event. Every time a job
+// is submitted again, e.g., when it is retried, its attempt count is incremented.
+func (b *JobStatusBoard) JobEvent(e Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	js, found := b.jobs[e.JobName]
+	if !found {
+		js = &JobStatus{Name: e.JobName}
+		b.jobs[e.JobName] = js
+	}
+	js.Processor = e.Processor
+	js.State = e.Type.String()
+	if e.JobID != "" {
+		js.ID = e.JobID
+	}
+	if e.Host != "" {
+		js.Host = e.Host
+	}
+	switch e.Type {
+	case Submitted:
+		js.Attempts++
+		js.Submitted = e.Time
+		js.Started = nil
+		js.Finished = nil
+		js.Error = ""
+	case Running:
+		t := e.Time
+		js.Started = &t
+	case Finished, Failed:
+		t := e.Time
+		js.Finished = &t
+		if e.Err != nil {
+			js.Error = e.Err.Error()
+		}
+	case Skipped:
+		t := e.Time
+		js.Submitted = t
+		js.Finished = &t
+	}
+}
+
+// Report returns the current status of all jobs ordered by their submission time
+func (b *JobStatusBoard) Report() JobStatusReport {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	r := JobStatusReport{
+		Started: b.started,
+		Jobs:    make([]JobStatus, 0, len(b.jobs)),
+	}
+	for _, js := range b.jobs {
+		r.Jobs = append(r.Jobs, *js)
+		r.Progress.Total++
+		switch js.State {
+		case Submitted.String(), Queued.String():
+			r.Progress.Queued++
+		case Running.String():
+			r.Progress.Running++
+		case Finished.String():
+			r.Progress.Finished++
+		case Failed.String():
+			r.Progress.Failed++
+		case Skipped.String():
+			r.Progress.Skipped++
+		}
+	}
+	sort.Slice(r.Jobs, func(i, j int) bool {
+		if r.Jobs[i].Submitted.Equal(r.Jobs[j].Submitted) {
+			return r.Jobs[i].Name < r.Jobs[j].Name
+		}
+		return r.Jobs[i].Submitted.Before(r.Jobs[j].Submitted)
+	})
+	return r
+}
+
+// JobStatus returns the status of the named job
+func (b *JobStatusBoard) JobStatus(name string) (JobStatus, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if js, found := b.jobs[name]; found {
+		return *js, true
+	}
+	return JobStatus{}, false
+}